@@ -20,6 +20,7 @@ fi
 
 echo "Downloading division data"
 overturemaps download --bbox "$bbox" -t division_area -f geoparquet -o "$download_directory/division_area.geoparquet"
+overturemaps download --bbox "$bbox" -t division -f geoparquet -o "$download_directory/division.geoparquet"
 
 echo "Downloading road data"
 overturemaps download --bbox "$bbox" -t segment -f geoparquet -o "$download_directory/segment.geoparquet"
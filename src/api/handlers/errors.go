@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "github.com/tebben/geocodeur/errors"
+	"github.com/tebben/geocodeur/service"
+)
+
+// responseStatus returns the HTTP status of a response with the error returned by a handler.
+func responseStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	var statusError interface{ GetStatus() int }
+	if errors.As(err, &statusError) {
+		return statusError.GetStatus()
+	}
+
+	return http.StatusInternalServerError
+}
+
+// serviceError returns the API error for an error of the geocoder by its kind: invalid input responds with 400,
+// missing features and datasets with 404, an unreachable database with 503 and searches stopped by the statement
+// timeout or the request timeout with 504. Other errors respond with 500 without the details of the error.
+func serviceError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return apierrors.NewAPIError(apierrors.StatusClientClosedRequest, apierrors.CodeCancelled, "The client closed the request")
+	}
+
+	switch service.Kind(err) {
+	case service.KindInvalidInput:
+		return apierrors.NewAPIError(http.StatusBadRequest, apierrors.CodeInvalidInput, err.Error())
+	case service.KindNotFound:
+		return apierrors.NewAPIError(http.StatusNotFound, apierrors.CodeNotFound, err.Error())
+	case service.KindUnavailable:
+		log.Errorf("Database unavailable: %v", err)
+		return apierrors.NewAPIError(http.StatusServiceUnavailable, apierrors.CodeUnavailable, "The database is unavailable, try again later")
+	case service.KindTimeout:
+		log.Warnf("Search timed out: %v", err)
+		return apierrors.NewAPIError(http.StatusGatewayTimeout, apierrors.CodeTimeout, "The search took too long, try a more specific query")
+	default:
+		log.Errorf("Request failed: %v", err)
+		return apierrors.NewAPIError(http.StatusInternalServerError, apierrors.CodeInternal, "The request failed because of an internal error")
+	}
+}
@@ -2,52 +2,73 @@ package handlers
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/danielgtaylor/huma/v2"
 	"github.com/tebben/geocodeur/errors"
+	"github.com/tebben/geocodeur/metrics"
 	"github.com/tebben/geocodeur/service"
 	"github.com/tebben/geocodeur/settings"
 )
 
 type GeocodeInput struct {
-	Query string   `required:"true" json:"q" query:"q" doc:"The search term to find a feature, the geocoder handles incomplete names and falls back to fuzzy search for typing errors. This way things as 'kerkstr ams' and 'kerkst masterdam' can still be found" example:"President Kennedylaan Amsterdam"`
-	Limit uint16   `required:"false" json:"limit" query:"limit" doc:"Maximum number of results to return" minimum:"1" maximum:"100" default:"10"`
-	Class []string `required:"false" json:"class" query:"class" doc:"Filter results by class, this is a comma separated list. Leave empty to query on all classes" enum:"division,water,road,address,zipcode,poi,infra" default:"division,water,road,address,zipcode,infra,poi" example:"division,water,road,poi,infra" uniqueItems:"true"`
-	Geom  bool     `required:"false" json:"geom" query:"geom" doc:"Include the geometry of the feature in the result" default:"false"`
+	Query   string   `required:"true" json:"q" query:"q" doc:"The search term to find a feature, the geocoder handles incomplete names and falls back to fuzzy search for typing errors. This way things as 'kerkstr ams' and 'kerkst masterdam' can still be found" example:"President Kennedylaan Amsterdam"`
+	Limit   uint16   `required:"false" json:"limit" query:"limit" doc:"Maximum number of results to return" minimum:"1" maximum:"100" default:"10"`
+	Class   []string `required:"false" json:"class" query:"class" doc:"Filter results by class, this is a comma separated list. Leave empty to query on all classes" enum:"division,water,road,address,zipcode,poi,infra" default:"division,water,road,address,zipcode,infra,poi" example:"division,water,road,poi,infra" uniqueItems:"true"`
+	Geom    bool     `required:"false" json:"geom" query:"geom" doc:"Include the geometry of the feature in the result" default:"false"`
+	Focus   string   `required:"false" json:"focus" query:"focus" doc:"Focus point formatted as lon,lat, results closer to the focus point rank higher when a distance weight is configured" example:"5.2913,51.6978"`
+	Explain bool     `required:"false" json:"explain" query:"explain" doc:"Include details on how each result was matched and ranked, and a timing breakdown of the query" default:"false"`
+	Dataset string   `required:"false" json:"dataset" query:"dataset" doc:"Name of the dataset to search, leave empty to search the first configured dataset" example:"geocodeur"`
 }
 
 type GeocodeResult struct {
 	Body struct {
-		QueryTime float32                 `json:"queryTime" doc:"Time in milliseconds it took to execute the query internally"`
-		Results   []service.GeocodeResult `json:"results"`
+		QueryTime   float32                 `json:"queryTime" doc:"Time in milliseconds it took to execute the query internally"`
+		Explain     *service.QueryExplain   `json:"explain,omitempty" doc:"Query details and timing breakdown, only set when explain is requested"`
+		Suggestions []string                `json:"suggestions,omitempty" doc:"Corrected queries to suggest when nothing was found, for example 'did you mean kerkstraat vught?'"`
+		Results     []service.GeocodeResult `json:"results"`
 	}
 }
 
-func GeocodeHandler(config settings.Config) func(ctx context.Context, input *struct {
+func GeocodeHandler(config settings.Config, datasets *service.Datasets) func(ctx context.Context, input *struct {
 	GeocodeInput
 }) (*GeocodeResult, error) {
 	return func(ctx context.Context, input *struct {
 		GeocodeInput
-	}) (*GeocodeResult, error) {
+	}) (result *GeocodeResult, err error) {
 
-		geocodeOptions, error := createGeocoderOptions(config, input.GeocodeInput)
-		if error != nil {
-			return nil, huma.Error400BadRequest(error.Error())
+		dataset, err := datasets.Get(input.Dataset)
+		if err != nil {
+			return nil, serviceError(err)
+		}
+
+		geocodeOptions, apiError := createGeocoderOptions(config, input.GeocodeInput)
+		if apiError != nil {
+			return nil, apiError
 		}
 
+		// Failed searches are observed as well with the status they respond with
 		timeStart := time.Now()
-		results, err := service.Geocode(config.Database.ConnectionString, geocodeOptions, input.Query)
+		var response service.GeocodeResponse
+		defer func() {
+			searchType := ""
+			if len(response.Results) > 0 {
+				searchType = response.Results[0].SearchType
+			}
+			metrics.ObserveGeocode(dataset.Name, searchType, len(response.Results), responseStatus(err), time.Since(timeStart))
+		}()
+
+		response, err = service.Geocode(ctx, dataset, geocodeOptions, input.Query)
 		if err != nil {
-			return nil, huma.Error400BadRequest(fmt.Sprintf("%v", err))
+			return nil, serviceError(err)
 		}
 
 		geocodeResult := &GeocodeResult{}
 		geocodeResult.Body.QueryTime = float32(time.Now().Sub(timeStart).Milliseconds())
-		geocodeResult.Body.Results = results
+		geocodeResult.Body.Explain = response.Explain
+		geocodeResult.Body.Suggestions = response.Suggestions
+		geocodeResult.Body.Results = response.Results
 
 		return geocodeResult, nil
 	}
@@ -56,10 +77,19 @@ func GeocodeHandler(config settings.Config) func(ctx context.Context, input *str
 func createGeocoderOptions(config settings.Config, input GeocodeInput) (service.GeocodeOptions, *errors.APIError) {
 	classes, err := getClasses(input)
 	if err != nil {
-		return service.GeocodeOptions{}, errors.NewAPIError(http.StatusBadRequest, err.Error(), nil)
+		return service.GeocodeOptions{}, errors.NewAPIError(http.StatusBadRequest, errors.CodeInvalidInput, err.Error())
+	}
+
+	options := service.NewGeocodeOptions(config.API.PGTRGMTreshold, input.Limit, classes, input.Geom, input.Explain)
+	if input.Focus != "" {
+		focus, err := service.ParsePoint(input.Focus)
+		if err != nil {
+			return service.GeocodeOptions{}, errors.NewAPIError(http.StatusBadRequest, errors.CodeInvalidInput, err.Error())
+		}
+		options.Focus = focus
 	}
 
-	return service.NewGeocodeOptions(config.API.PGTRGMTreshold, input.Limit, classes, input.Geom), nil
+	return options, nil
 }
 
 func getClasses(input GeocodeInput) ([]service.Class, error) {
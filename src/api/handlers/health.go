@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/tebben/geocodeur/service"
+)
+
+// readyTimeout limits how long the readiness checks of all datasets may take.
+const readyTimeout = 5 * time.Second
+
+type LiveResult struct {
+	Body struct {
+		Status string `json:"status" doc:"Always ok while the server is running" example:"ok"`
+	}
+}
+
+type ReadyResult struct {
+	Status int
+	Body   struct {
+		Ready    bool                    `json:"ready" doc:"Whether all datasets can serve requests"`
+		Datasets []service.DatasetHealth `json:"datasets" doc:"Readiness and metadata of every dataset"`
+	}
+}
+
+// LiveHandler reports that the server is running, it doesn't check the datasets.
+func LiveHandler() func(ctx context.Context, input *struct{}) (*LiveResult, error) {
+	return func(ctx context.Context, input *struct{}) (*LiveResult, error) {
+		liveResult := &LiveResult{}
+		liveResult.Body.Status = "ok"
+
+		return liveResult, nil
+	}
+}
+
+// ReadyHandler reports if all datasets can serve requests, it responds with 503 when a dataset is not ready.
+func ReadyHandler(datasets *service.Datasets) func(ctx context.Context, input *struct{}) (*ReadyResult, error) {
+	return func(ctx context.Context, input *struct{}) (*ReadyResult, error) {
+		ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+		defer cancel()
+
+		readyResult := &ReadyResult{Status: http.StatusOK}
+		readyResult.Body.Ready = true
+		for _, dataset := range datasets.All() {
+			health := service.CheckDataset(ctx, dataset)
+			if !health.Ready {
+				readyResult.Status = http.StatusServiceUnavailable
+				readyResult.Body.Ready = false
+			}
+			readyResult.Body.Datasets = append(readyResult.Body.Datasets, health)
+		}
+
+		return readyResult, nil
+	}
+}
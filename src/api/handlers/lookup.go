@@ -2,15 +2,13 @@ package handlers
 
 import (
 	"context"
-	"fmt"
 
-	"github.com/danielgtaylor/huma/v2"
 	"github.com/tebben/geocodeur/service"
-	"github.com/tebben/geocodeur/settings"
 )
 
 type LookupInput struct {
-	ID uint64 `required:"true" json:"limit" path:"id" doc:"Maximum number of results to return" minimum:"0" example:"40231"`
+	ID      uint64 `required:"true" json:"id" path:"id" doc:"The id of the feature, not the original Overture id" minimum:"0" example:"40231"`
+	Dataset string `required:"false" json:"dataset" query:"dataset" doc:"Name of the dataset to lookup the feature in, leave empty to use the first configured dataset" example:"geocodeur"`
 }
 
 type LookupResult struct {
@@ -19,15 +17,20 @@ type LookupResult struct {
 	}
 }
 
-func LookupHandler(config settings.Config) func(ctx context.Context, input *struct {
+func LookupHandler(datasets *service.Datasets) func(ctx context.Context, input *struct {
 	LookupInput
 }) (*LookupResult, error) {
 	return func(ctx context.Context, input *struct {
 		LookupInput
 	}) (*LookupResult, error) {
-		result, err := service.Lookup(config.Database.ConnectionString, input.ID)
+		dataset, err := datasets.Get(input.Dataset)
 		if err != nil {
-			return nil, huma.Error400BadRequest(fmt.Sprintf("%v", err))
+			return nil, serviceError(err)
+		}
+
+		result, err := service.Lookup(ctx, dataset, input.ID)
+		if err != nil {
+			return nil, serviceError(err)
 		}
 
 		lookupResult := &LookupResult{}
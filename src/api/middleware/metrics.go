@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/tebben/geocodeur/metrics"
+)
+
+// Metrics returns a middleware recording the count and duration of requests by route pattern, it has to run
+// before the throttle so rejected requests are counted too.
+func Metrics() func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			t1 := time.Now()
+
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+
+				// Requests rejected by the throttle never reach a route
+				route := chi.RouteContext(r.Context()).RoutePattern()
+				if route == "" && status == http.StatusTooManyRequests {
+					metrics.ObserveThrottled()
+					route = "throttled"
+				} else if route == "" {
+					route = "unmatched"
+				}
+
+				metrics.ObserveRequest(route, r.Method, status, time.Since(t1))
+			}()
+
+			h.ServeHTTP(ww, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
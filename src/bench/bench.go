@@ -0,0 +1,263 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxExamples is the number of errors and differences kept as examples in a report.
+const maxExamples = 10
+
+// Request is a geocode request to replay, it has the query parameters of the geocode endpoint.
+type Request struct {
+	Query   string   `json:"q"`
+	Limit   uint16   `json:"limit,omitempty"`
+	Class   []string `json:"class,omitempty"`
+	Focus   string   `json:"focus,omitempty"`
+	Dataset string   `json:"dataset,omitempty"`
+}
+
+// Result is a geocode result as returned by a target, it's only used to compare results.
+type Result struct {
+	Name      string `json:"name"`
+	Class     string `json:"class"`
+	Subclass  string `json:"subclass"`
+	Divisions string `json:"divisions"`
+}
+
+// key identifies a result independent of the ids, which differ between databases loaded separately.
+func (r Result) key() string {
+	return strings.Join([]string{r.Name, r.Class, r.Subclass, r.Divisions}, "|")
+}
+
+// Target executes geocode requests, it's a running server or the service itself.
+type Target interface {
+	Geocode(ctx context.Context, request Request) ([]Result, error)
+}
+
+// Options configure how requests are replayed, a rate of 0 sends requests as fast as the workers can.
+type Options struct {
+	Concurrency int
+	Rate        float64
+	Repeat      int
+}
+
+// Report contains the latencies and errors of a replay and the differences with the compare target.
+type Report struct {
+	Requests    int
+	Errors      int
+	Duration    time.Duration
+	Compared    int
+	Differences int
+	ErrorCounts map[string]int
+	Examples    []string
+	latencies   []time.Duration
+}
+
+// ReadRequests reads a file with a JSON request on every line, empty lines are skipped.
+func ReadRequests(path string) ([]Request, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening requests: %v", err)
+	}
+	defer f.Close()
+
+	var requests []Request
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var request Request
+		if err := json.Unmarshal([]byte(text), &request); err != nil {
+			return nil, fmt.Errorf("error reading %s line %d: %v", path, line, err)
+		}
+
+		if request.Query == "" {
+			return nil, fmt.Errorf("error reading %s line %d: q is empty", path, line)
+		}
+
+		requests = append(requests, request)
+	}
+
+	return requests, scanner.Err()
+}
+
+// Run replays the requests on the target with the configured concurrency and rate. When a compare
+// target is given every request is also sent to it and the results of both are compared.
+func Run(ctx context.Context, target Target, compare Target, requests []Request, options Options) Report {
+	report := Report{ErrorCounts: make(map[string]int)}
+	jobs := make(chan Request)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < max(options.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for request := range jobs {
+				timeStart := time.Now()
+				results, err := target.Geocode(ctx, request)
+				latency := time.Since(timeStart)
+
+				var difference string
+				compared := false
+				if err == nil && compare != nil {
+					other, compareErr := compare.Geocode(ctx, request)
+					if compareErr == nil {
+						compared = true
+						difference = diff(request, results, other)
+					}
+				}
+
+				mutex.Lock()
+				report.Requests++
+				report.latencies = append(report.latencies, latency)
+				if err != nil {
+					report.Errors++
+					report.ErrorCounts[err.Error()]++
+				}
+				if compared {
+					report.Compared++
+				}
+				if difference != "" {
+					report.Differences++
+					if len(report.Examples) < maxExamples {
+						report.Examples = append(report.Examples, difference)
+					}
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if options.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / options.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	timeStart := time.Now()
+send:
+	for i := 0; i < max(options.Repeat, 1); i++ {
+		for _, request := range requests {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					break send
+				}
+			}
+
+			select {
+			case jobs <- request:
+			case <-ctx.Done():
+				break send
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	report.Duration = time.Since(timeStart)
+
+	return report
+}
+
+// diff describes the difference between the results of two targets, empty when they are the same.
+func diff(request Request, results []Result, other []Result) string {
+	if len(results) == len(other) {
+		same := true
+		for i := range results {
+			if results[i].key() != other[i].key() {
+				same = false
+				break
+			}
+		}
+
+		if same {
+			return ""
+		}
+	}
+
+	first := func(results []Result) string {
+		if len(results) == 0 {
+			return "no results"
+		}
+		return fmt.Sprintf("%s (%s)", results[0].Name, results[0].Class)
+	}
+
+	return fmt.Sprintf("%s: %d results, first %s <> %d results, first %s", request.Query, len(results), first(results), len(other), first(other))
+}
+
+// Percentile returns the latency below which the given percentage of the requests completed.
+func (r Report) Percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration{}, r.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+// Throughput returns the number of requests per second.
+func (r Report) Throughput() float64 {
+	if r.Duration == 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+// ErrorRate returns the fraction of requests that failed.
+func (r Report) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+// Print writes the throughput, latency percentiles, errors and differences of a report.
+func Print(w io.Writer, report Report) {
+	fmt.Fprintf(w, "Requests:    %d in %v (%.1f req/s)\n", report.Requests, report.Duration.Round(time.Millisecond), report.Throughput())
+	fmt.Fprintf(w, "Errors:      %d (%.2f%%)\n", report.Errors, report.ErrorRate()*100)
+	fmt.Fprintf(w, "Latency:     p50 %v, p90 %v, p95 %v, p99 %v, max %v\n",
+		report.Percentile(50), report.Percentile(90), report.Percentile(95), report.Percentile(99), report.Percentile(100))
+
+	if len(report.ErrorCounts) > 0 {
+		messages := make([]string, 0, len(report.ErrorCounts))
+		for message := range report.ErrorCounts {
+			messages = append(messages, message)
+		}
+		sort.Slice(messages, func(i, j int) bool { return report.ErrorCounts[messages[i]] > report.ErrorCounts[messages[j]] })
+
+		fmt.Fprintln(w, "Error counts:")
+		for i, message := range messages {
+			if i == maxExamples {
+				break
+			}
+			fmt.Fprintf(w, "  %d x %s\n", report.ErrorCounts[message], message)
+		}
+	}
+
+	if report.Compared > 0 {
+		fmt.Fprintf(w, "Compared:    %d, %d with different results\n", report.Compared, report.Differences)
+		for _, example := range report.Examples {
+			fmt.Fprintf(w, "  %s\n", example)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTarget returns the results of a query from a map and fails for unknown queries.
+type fakeTarget map[string][]Result
+
+func (t fakeTarget) Geocode(ctx context.Context, request Request) ([]Result, error) {
+	results, ok := t[request.Query]
+	if !ok {
+		return nil, errors.New("unknown query")
+	}
+	return results, nil
+}
+
+func TestReadRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	content := `{"q": "kerkstraat", "limit": 5, "class": ["road"], "focus": "5.29,51.65"}
+
+{"q": "vught", "dataset": "nl"}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, err := ReadRequests(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 2 || requests[0].Limit != 5 || requests[0].Class[0] != "road" || requests[1].Dataset != "nl" {
+		t.Errorf("ReadRequests() = %+v", requests)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"limit": 5}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadRequests(path); err == nil || !strings.Contains(err.Error(), "line 1: q is empty") {
+		t.Errorf("ReadRequests() error = %v, want q is empty", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	kerkstraat := []Result{{Name: "Kerkstraat", Class: "road"}, {Name: "Kerkstraat", Class: "poi"}}
+	target := fakeTarget{"kerkstraat": kerkstraat, "vught": {{Name: "Vught", Class: "division"}}}
+	compare := fakeTarget{"kerkstraat": {kerkstraat[1], kerkstraat[0]}, "vught": {{Name: "Vught", Class: "division"}}}
+	requests := []Request{{Query: "kerkstraat"}, {Query: "vught"}, {Query: "unknown"}}
+
+	report := Run(context.Background(), target, compare, requests, Options{Concurrency: 2, Repeat: 2})
+
+	if report.Requests != 6 || report.Errors != 2 || report.ErrorCounts["unknown query"] != 2 {
+		t.Errorf("requests = %d, errors = %d %v, want 6 and 2", report.Requests, report.Errors, report.ErrorCounts)
+	}
+
+	if report.Compared != 4 || report.Differences != 2 || len(report.Examples) != 2 {
+		t.Errorf("compared = %d, differences = %d, want 4 and 2", report.Compared, report.Differences)
+	}
+
+	if want := "kerkstraat: 2 results, first Kerkstraat (road) <> 2 results, first Kerkstraat (poi)"; report.Examples[0] != want {
+		t.Errorf("example = %q, want %q", report.Examples[0], want)
+	}
+
+	if rate := report.ErrorRate(); rate != 2.0/6 {
+		t.Errorf("ErrorRate() = %v, want %v", rate, 2.0/6)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report := Run(ctx, fakeTarget{"vught": nil}, nil, []Request{{Query: "vught"}}, Options{Repeat: 100, Rate: 1})
+	if report.Requests != 0 {
+		t.Errorf("requests = %d after cancel, want 0", report.Requests)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	report := Report{}
+	if p := report.Percentile(50); p != 0 {
+		t.Errorf("Percentile(50) without requests = %v, want 0", p)
+	}
+
+	for i := 10; i >= 1; i-- {
+		report.latencies = append(report.latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{50, 5 * time.Millisecond},
+		{90, 9 * time.Millisecond},
+		{99, 10 * time.Millisecond},
+		{100, 10 * time.Millisecond},
+	} {
+		if got := report.Percentile(tt.p); got != tt.want {
+			t.Errorf("Percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tebben/geocodeur/service"
+)
+
+// HTTPTarget sends requests to the geocode endpoint of a running server.
+type HTTPTarget struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPTarget creates a target for the server at the base url.
+func NewHTTPTarget(baseURL string, timeout time.Duration) *HTTPTarget {
+	return &HTTPTarget{url: strings.TrimRight(baseURL, "/") + "/geocode", client: &http.Client{Timeout: timeout}}
+}
+
+func (t *HTTPTarget) Geocode(ctx context.Context, request Request) ([]Result, error) {
+	params := url.Values{}
+	params.Set("q", request.Query)
+	if request.Limit > 0 {
+		params.Set("limit", strconv.Itoa(int(request.Limit)))
+	}
+	if len(request.Class) > 0 {
+		params.Set("class", strings.Join(request.Class, ","))
+	}
+	if request.Focus != "" {
+		params.Set("focus", request.Focus)
+	}
+	if request.Dataset != "" {
+		params.Set("dataset", request.Dataset)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// Errors are counted by message so the url with the query is left out
+		if urlErr, ok := err.(*url.Error); ok {
+			if urlErr.Timeout() {
+				return nil, fmt.Errorf("timeout")
+			}
+			return nil, fmt.Errorf("request failed: %v", urlErr.Err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Results []Result `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid response")
+	}
+
+	return body.Results, nil
+}
+
+// ServiceTarget geocodes requests in process, without the HTTP server.
+type ServiceTarget struct {
+	datasets  *service.Datasets
+	threshold float64
+}
+
+// NewServiceTarget creates a target geocoding in the given datasets.
+func NewServiceTarget(datasets *service.Datasets, threshold float64) *ServiceTarget {
+	return &ServiceTarget{datasets: datasets, threshold: threshold}
+}
+
+func (t *ServiceTarget) Geocode(ctx context.Context, request Request) ([]Result, error) {
+	dataset, err := t.datasets.Get(request.Dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	classes := make([]service.Class, len(request.Class))
+	for i, c := range request.Class {
+		classes[i], err = service.StringToClass(strings.ToLower(c))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	limit := request.Limit
+	if limit == 0 {
+		limit = 10
+	}
+
+	options := service.NewGeocodeOptions(t.threshold, limit, classes, false, false)
+	if request.Focus != "" {
+		options.Focus, err = service.ParsePoint(request.Focus)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := service.Geocode(ctx, dataset, options, request.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(response.Results))
+	for i, r := range response.Results {
+		results[i] = Result{Name: r.Name, Class: r.Class, Subclass: r.Subclass, Divisions: r.Divisions}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tebben/geocodeur/settings"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show the configuration",
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Print the effective config with the passwords hidden",
+	Long: `Print the effective config as JSON: the config file merged with the GEOCODEUR_ environment variables,
+the --set overrides and the defaults. Passwords in the connection strings are hidden.`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{writesResults: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		if file := settings.LoadedFile(); file != "" {
+			log.Infof("Loaded config file %s", file)
+		}
+
+		writeJSON(cmd.OutOrStdout(), config.Redacted())
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configPrintCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tebben/geocodeur/database"
+	"github.com/tebben/geocodeur/preprocess"
+	"github.com/tebben/geocodeur/settings"
+)
+
+var (
+	dataFolder string
+	country    string
+	backend    string
+	createFile string
+	indexFile  string
+	release    string
+)
+
+var processCmd = &cobra.Command{
+	Use:   "process",
+	Short: "Preprocess Overture Maps data into parquet files",
+	Long:  "Preprocess Overture Maps data with DuckDB into the parquet files that are loaded with create, index build and update.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		preprocess.ProcessAll(config.Process)
+	},
+}
+
+var createCmd = &cobra.Command{
+	Use:   "create [dataset]",
+	Short: "Load the preprocessed data into PostgreSQL or a SQLite file",
+	Long: `Load the preprocessed data into PostgreSQL or a SQLite file, the backend and file default to
+those of the dataset and the first dataset is used when no dataset is given.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dataset, err := config.Dataset(datasetArg(args, 0))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if backend == "" {
+			backend = dataset.Backend
+		}
+
+		if createFile == "" {
+			createFile = dataset.File
+		}
+
+		switch backend {
+		case settings.BackendPostgres:
+			database.CreateDB(cmd.Context(), withDataset(config, dataset.Name))
+		case settings.BackendSQLite:
+			database.CreateSQLite(config, createFile)
+		case settings.BackendIndex:
+			log.Fatal("Index files are built with index build")
+		default:
+			log.Fatalf("Unknown backend '%s', use postgres or sqlite", backend)
+		}
+	},
+}
+
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Manage index files to search without a database",
+}
+
+var indexBuildCmd = &cobra.Command{
+	Use:   "build [dataset]",
+	Short: "Build an index file from the preprocessed data",
+	Long:  "Build an index file from the preprocessed data, the file defaults to the file of an index dataset or <dataset>.idx.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dataset, err := config.Dataset(datasetArg(args, 0))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if indexFile == "" && dataset.Backend == settings.BackendIndex {
+			indexFile = dataset.File
+		} else if indexFile == "" {
+			indexFile = dataset.Name + ".idx"
+		}
+
+		database.BuildIndex(config, indexFile)
+	},
+}
+
+var updateCmd = &cobra.Command{
+	Use:   "update [dataset]",
+	Short: "Apply the changed features of a new release to PostgreSQL",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := database.Update(cmd.Context(), withDataset(config, datasetArg(args, 0)))
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [dataset]",
+	Short: "Swap the previous tables back in after a bad create",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		database.Rollback(cmd.Context(), withDataset(config, datasetArg(args, 0)))
+	},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the tables in PostgreSQL to the schema version of this release",
+	Long:  "Migrate the tables of a dataset in PostgreSQL, the schema version is stored in a table named after the overture table of the dataset.",
+}
+
+func init() {
+	processCmd.Flags().StringVar(&dataFolder, "folder", "", "Data folder, overrides process.folder")
+	processCmd.Flags().StringVar(&country, "country", "", "Country to clip the data to, overrides process.countryClip")
+	configFlag(processCmd, "folder", "process.folder")
+	configFlag(processCmd, "country", "process.countryClip")
+
+	createCmd.Flags().StringVar(&backend, "backend", "", "Backend to create, postgres or sqlite")
+	createCmd.Flags().StringVar(&createFile, "file", "", "SQLite file to create")
+	createCmd.Flags().StringVar(&dataFolder, "folder", "", "Folder with the preprocessed data, overrides process.folder")
+	configFlag(createCmd, "folder", "process.folder")
+	createCmd.Flags().StringVar(&release, "release", "", "Overture release of the data, overrides process.release")
+	configFlag(createCmd, "release", "process.release")
+
+	indexBuildCmd.Flags().StringVar(&indexFile, "file", "", "Index file to build")
+	indexBuildCmd.Flags().StringVar(&dataFolder, "folder", "", "Folder with the preprocessed data, overrides process.folder")
+	configFlag(indexBuildCmd, "folder", "process.folder")
+	indexCmd.AddCommand(indexBuildCmd)
+
+	updateCmd.Flags().StringVar(&dataFolder, "folder", "", "Folder with the preprocessed data, overrides process.folder")
+	configFlag(updateCmd, "folder", "process.folder")
+	updateCmd.Flags().StringVar(&release, "release", "", "Overture release of the data, overrides process.release")
+	configFlag(updateCmd, "release", "process.release")
+
+	for _, action := range []struct{ name, short string }{
+		{"up", "Apply all pending migrations"},
+		{"down", "Revert the last migration"},
+		{"status", "Show the schema version and pending migrations"},
+	} {
+		migrateCmd.AddCommand(&cobra.Command{
+			Use:   action.name + " [dataset]",
+			Short: action.short,
+			Args:  cobra.MaximumNArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				database.Migrate(cmd.Context(), withDataset(config, datasetArg(args, 0)), action.name)
+			},
+		})
+	}
+
+	rootCmd.AddCommand(processCmd, createCmd, indexCmd, updateCmd, rollbackCmd, migrateCmd)
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tebben/geocodeur/service"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+var (
+	datasetName string
+	limit       uint16
+	classNames  []string
+	focus       string
+	geom        bool
+	explain     bool
+	output      string
+)
+
+var queryCmd = &cobra.Command{
+	Use:   "query <text>...",
+	Short: "Geocode a text",
+	Long:  "Geocode a text like the geocode endpoint, the arguments are joined with spaces.",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutput()
+		classes := parseClasses()
+		dataset := openDataset(config, datasetName)
+		defer startTracing()()
+
+		options := service.NewGeocodeOptions(config.API.PGTRGMTreshold, limit, classes, geom, explain)
+		if focus != "" {
+			point, err := service.ParsePoint(focus)
+			if err != nil {
+				log.Fatal(err)
+			}
+			options.Focus = point
+		}
+
+		response, err := service.Geocode(cmd.Context(), dataset, options, strings.Join(args, " "))
+		if err != nil {
+			log.Fatalf("Failed to geocode: %v", err)
+		}
+
+		if output == outputJSON {
+			// Same body as the geocode endpoint without the query time
+			writeJSON(cmd.OutOrStdout(), struct {
+				Explain     *service.QueryExplain   `json:"explain,omitempty"`
+				Suggestions []string                `json:"suggestions,omitempty"`
+				Results     []service.GeocodeResult `json:"results"`
+			}{response.Explain, response.Suggestions, response.Results})
+			return
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tCLASS\tSUBCLASS\tDIVISIONS\tALIAS\tSEARCH\tSIMILARITY")
+		for _, result := range response.Results {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%.3f\n", result.ID, result.Name, result.Class, result.Subclass,
+				result.Divisions, result.Alias, result.SearchType, result.Similarity)
+		}
+		w.Flush()
+
+		if len(response.Results) == 0 && len(response.Suggestions) > 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "\nDid you mean: %s\n", strings.Join(response.Suggestions, ", "))
+		}
+
+		if response.Explain != nil {
+			timing := response.Explain.Timing
+			fmt.Fprintf(cmd.OutOrStdout(), "\ntsquery: %s\nbuild %.2fms, execute %.2fms, parse %.2fms, total %.2fms\n",
+				response.Explain.TSQuery, timing.Build, timing.Execute, timing.Parse, timing.Total)
+		}
+	},
+}
+
+var lookupCmd = &cobra.Command{
+	Use:   "lookup <id>",
+	Short: "Look up a feature by id",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutput()
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid id %s", args[0])
+		}
+
+		dataset := openDataset(config, datasetName)
+		defer startTracing()()
+
+		result, err := service.Lookup(cmd.Context(), dataset, id)
+		if err != nil {
+			log.Fatalf("Failed to look up feature: %v", err)
+		}
+
+		if output == outputJSON {
+			writeJSON(cmd.OutOrStdout(), result)
+			return
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\t%d\n", result.ID)
+		fmt.Fprintf(w, "NAME\t%s\n", result.Name)
+		fmt.Fprintf(w, "CLASS\t%s\n", result.Class)
+		fmt.Fprintf(w, "SUBCLASS\t%s\n", result.Subclass)
+		fmt.Fprintf(w, "DIVISIONS\t%s\n", result.Divisions)
+		fmt.Fprintf(w, "GEOM\t%s\n", result.Geom)
+		w.Flush()
+	},
+}
+
+var reverseCmd = &cobra.Command{
+	Use:   "reverse <lon,lat>",
+	Short: "Find the features closest to a point",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutput()
+		point, err := service.ParsePoint(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		classes := parseClasses()
+		dataset := openDataset(config, datasetName)
+		defer startTracing()()
+
+		results, err := service.Reverse(cmd.Context(), dataset, service.NewReverseOptions(*point, limit, classes, geom))
+		if err != nil {
+			log.Fatalf("Failed to reverse geocode: %v", err)
+		}
+
+		if output == outputJSON {
+			writeJSON(cmd.OutOrStdout(), results)
+			return
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tCLASS\tSUBCLASS\tDIVISIONS\tDISTANCE (KM)")
+		for _, result := range results {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%.3f\n", result.ID, result.Name, result.Class, result.Subclass,
+				result.Divisions, result.DistanceKm)
+		}
+		w.Flush()
+	},
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the number of features per class and the number of aliases of a dataset",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutput()
+		datasets, err := service.OpenDatasets(withDataset(config, datasetName))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		stats, err := service.DatasetStats(cmd.Context(), datasets.All()[0])
+		if err != nil {
+			log.Fatalf("Failed to get stats: %v", err)
+		}
+
+		if output == outputJSON {
+			writeJSON(cmd.OutOrStdout(), stats)
+			return
+		}
+
+		classes := make([]string, 0, len(stats.Classes))
+		for class := range stats.Classes {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CLASS\tFEATURES")
+		for _, class := range classes {
+			fmt.Fprintf(w, "%s\t%d\n", class, stats.Classes[class])
+		}
+		fmt.Fprintf(w, "total\t%d\n", stats.Features)
+		fmt.Fprintf(w, "\nALIASES\t%d\n", stats.Aliases)
+		w.Flush()
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{queryCmd, lookupCmd, reverseCmd, statsCmd} {
+		cmd.Flags().StringVarP(&datasetName, "dataset", "d", "", "Dataset to search, the first dataset when not given")
+		cmd.Flags().StringVarP(&output, "output", "o", outputTable, "Output format, table or json")
+		cmd.Annotations = map[string]string{writesResults: "true"}
+	}
+
+	for _, cmd := range []*cobra.Command{queryCmd, reverseCmd} {
+		cmd.Flags().Uint16VarP(&limit, "limit", "l", 10, "Maximum number of results")
+		cmd.Flags().StringSliceVarP(&classNames, "class", "c", nil, "Classes to search in, comma separated")
+		cmd.Flags().BoolVar(&geom, "geom", false, "Include the geometries in the results")
+	}
+	queryCmd.Flags().StringVar(&focus, "focus", "", "Rank results closer to this lon,lat point higher")
+	queryCmd.Flags().BoolVar(&explain, "explain", false, "Show how the results were matched and ranked")
+
+	rootCmd.AddCommand(queryCmd, lookupCmd, reverseCmd, statsCmd)
+}
+
+func checkOutput() {
+	if output != outputTable && output != outputJSON {
+		log.Fatalf("Unknown output format '%s', use table or json", output)
+	}
+}
+
+func parseClasses() []service.Class {
+	var classes []service.Class
+	for _, name := range classNames {
+		class, err := service.StringToClass(strings.TrimSpace(name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		classes = append(classes, class)
+	}
+
+	return classes
+}
+
+func writeJSON(w io.Writer, v any) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Fatalf("Failed to write JSON: %v", err)
+	}
+}
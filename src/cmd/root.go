@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tebben/geocodeur/service"
+	"github.com/tebben/geocodeur/settings"
+	"github.com/tebben/geocodeur/tracing"
+)
+
+var (
+	// version is set at build time with -ldflags "-X github.com/tebben/geocodeur/cmd.version=..."
+	version = "dev"
+
+	config     settings.Config
+	configPath string
+	overrides  []string
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "geocodeur",
+	Short: "Geocoder for Overture Maps data",
+	Long: `Geocodeur is a geocoder for Overture Maps data. The data is preprocessed into parquet files with
+process, loaded into PostgreSQL, a SQLite file or an index file and served by server.`,
+	SilenceUsage: true,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		loadConfig(cmd)
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Config file, defaults to GEOCODEUR_CONFIG_PATH or ../config/geocodeur.conf")
+	rootCmd.PersistentFlags().StringArrayVar(&overrides, "set", nil, "Override a config field, for example --set database.maxConnections=10")
+}
+
+// Execute runs the command given on the command line.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// configKey annotates the flags overriding a config field with the key of the field.
+const configKey = "configKey"
+
+// configFlag makes a flag of a command override a config field, key is the path of JSON keys joined with dots.
+func configFlag(cmd *cobra.Command, name string, key string) {
+	cmd.Flags().SetAnnotation(name, configKey, []string{key})
+}
+
+// writesResults annotates the commands writing results to stdout, they log to stderr.
+const writesResults = "writesResults"
+
+func loadConfig(cmd *cobra.Command) {
+	if configPath != "" {
+		settings.SetConfigLocation(configPath)
+	}
+	// Flags of config fields are applied after --set, they are kept when the config is reloaded
+	fieldOverrides := overrides
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if keys := flag.Annotations[configKey]; len(keys) > 0 {
+			fieldOverrides = append(fieldOverrides, keys[0]+"="+flag.Value.String())
+		}
+	})
+	settings.SetOverrides(fieldOverrides)
+
+	err := settings.InitializeConfig()
+	var invalid *settings.ValidationError
+	if errors.As(err, &invalid) {
+		for _, problem := range invalid.Problems {
+			log.Error(problem)
+		}
+		log.Fatalf("Invalid configuration, %d problems found", len(invalid.Problems))
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	config = settings.GetConfig()
+	initLogger(config)
+	if cmd.Annotations[writesResults] != "" {
+		log.SetOutput(os.Stderr)
+	}
+
+	if settings.LoadedFile() == "" {
+		log.Warn("No config file found, using the environment variables and defaults")
+	}
+}
+
+func initLogger(config settings.Config) {
+	log.SetOutput(os.Stdout)
+	log.SetLevel(log.InfoLevel)
+	if config.Server.Debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	log.SetFormatter(&log.TextFormatter{
+		DisableColors: false,
+		FullTimestamp: true,
+	})
+}
+
+// withDataset returns the config with only the named dataset and its database, the first
+// dataset is used when no name is given.
+func withDataset(config settings.Config, name string) settings.Config {
+	dataset, err := config.Dataset(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.Database = dataset.Database
+	config.Datasets = []settings.DatasetConfig{dataset}
+	return config
+}
+
+// openDataset opens the store of the named dataset and loads its vocabulary and the synonyms.
+func openDataset(config settings.Config, name string) *service.Dataset {
+	datasets, err := service.OpenDatasets(withDataset(config, name))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	service.SetSynonyms(service.NewSynonyms(config.Aliases))
+
+	dataset := datasets.All()[0]
+	err = service.LoadVocabulary(dataset)
+	if err != nil {
+		log.Warnf("Failed to load vocabulary, misspelled words are only matched by trigram: %v", err)
+	}
+
+	return dataset
+}
+
+// datasetArg returns the dataset name given as argument at index i, empty when it's not given.
+func datasetArg(args []string, i int) string {
+	if len(args) > i {
+		return args[i]
+	}
+	return ""
+}
+
+// startTracing starts tracing with the tracing config, the returned function flushes the spans that are left.
+func startTracing() func() {
+	shutdown, err := tracing.Start(config.Tracing, version)
+	if err != nil {
+		log.Fatalf("Failed to start tracing: %v", err)
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdown(ctx); err != nil {
+			log.Warnf("Failed to flush traces: %v", err)
+		}
+	}
+}
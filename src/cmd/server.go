@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tebben/geocodeur/server"
+)
+
+var port int
+
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Start the geocode API",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		defer startTracing()()
+		server.Start(config)
+	},
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version",
+	Args:  cobra.NoArgs,
+	// The version is printed without loading the config
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Fprintf(cmd.OutOrStdout(), "geocodeur %s\n", version)
+	},
+}
+
+func init() {
+	serverCmd.Flags().IntVar(&port, "port", 8080, "Port to listen on, overrides server.port")
+	configFlag(serverCmd, "port", "server.port")
+
+	rootCmd.AddCommand(serverCmd, versionCmd)
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tebben/geocodeur/bench"
+	"github.com/tebben/geocodeur/eval"
+	"github.com/tebben/geocodeur/service"
+	"github.com/tebben/geocodeur/settings"
+)
+
+var (
+	goldFile      string
+	reportFile    string
+	compareReport string
+	evalLimit     uint16
+
+	requestsFile string
+	baseURL      string
+	compareURL   string
+	concurrency  int
+	rate         float64
+	repeat       int
+	timeout      time.Duration
+)
+
+var evalCmd = &cobra.Command{
+	Use:   "eval [dataset]",
+	Short: "Report the search quality on the queries of a gold file",
+	Long: `Run the queries of a gold file on a dataset and report recall@1, recall@5 and MRR per class,
+compared to a previous report when --compare is given.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		queries, err := eval.ReadGold(goldFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var previous *eval.Report
+		if compareReport != "" {
+			report, err := eval.ReadReport(compareReport)
+			if err != nil {
+				log.Fatal(err)
+			}
+			previous = &report
+		}
+
+		dataset := openDataset(config, datasetArg(args, 0))
+
+		log.Infof("Evaluating %d queries on dataset %s", len(queries), dataset.Name)
+		report, err := eval.Run(cmd.Context(), dataset, queries, config.API.PGTRGMTreshold, evalLimit)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		eval.Print(cmd.OutOrStdout(), report, previous)
+
+		if reportFile != "" {
+			err = eval.WriteReport(reportFile, report)
+			if err != nil {
+				log.Fatalf("Failed to write report: %v", err)
+			}
+			log.Infof("Report written to %s", reportFile)
+		}
+	},
+}
+
+var benchCmd = &cobra.Command{
+	Use:   "bench [dataset]",
+	Short: "Replay geocode requests and report latencies, errors and throughput",
+	Long: `Replay the geocode requests of a JSONL file against a running server, or in process when no url is
+given, and report latencies, errors and throughput. With --compare the results are compared with a second server.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		requests, err := bench.ReadRequests(requestsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var target bench.Target
+		if baseURL != "" {
+			target = bench.NewHTTPTarget(baseURL, timeout)
+		} else {
+			if len(args) > 0 {
+				dataset, err := config.Dataset(args[0])
+				if err != nil {
+					log.Fatal(err)
+				}
+				config.Datasets = []settings.DatasetConfig{dataset}
+			}
+
+			datasets, err := service.OpenDatasets(config)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			service.SetSynonyms(service.NewSynonyms(config.Aliases))
+			for _, dataset := range datasets.All() {
+				err = service.LoadVocabulary(dataset)
+				if err != nil {
+					log.Warnf("Failed to load vocabulary of dataset %s: %v", dataset.Name, err)
+				}
+			}
+			target = bench.NewServiceTarget(datasets, config.API.PGTRGMTreshold)
+		}
+
+		var compare bench.Target
+		if compareURL != "" {
+			compare = bench.NewHTTPTarget(compareURL, timeout)
+		}
+
+		log.Infof("Replaying %d requests %d times with %d concurrent requests", len(requests), max(repeat, 1), max(concurrency, 1))
+		report := bench.Run(cmd.Context(), target, compare, requests, bench.Options{Concurrency: concurrency, Rate: rate, Repeat: repeat})
+		bench.Print(cmd.OutOrStdout(), report)
+	},
+}
+
+func init() {
+	evalCmd.Flags().StringVar(&goldFile, "gold", "", "Gold file with a JSON query on every line")
+	evalCmd.Flags().StringVar(&reportFile, "out", "", "File to write the report to")
+	evalCmd.Flags().StringVar(&compareReport, "compare", "", "Previous report to compare with")
+	evalCmd.Flags().Uint16Var(&evalLimit, "limit", 10, "Number of results to search the expected feature in, at least 5")
+	evalCmd.MarkFlagRequired("gold")
+
+	benchCmd.Flags().StringVar(&requestsFile, "file", "", "File with a JSON geocode request on every line")
+	benchCmd.Flags().StringVar(&baseURL, "url", "", "Base url of the server to replay against, geocode in process when empty")
+	benchCmd.Flags().StringVar(&compareURL, "compare", "", "Base url of a server to compare the results with")
+	benchCmd.Flags().IntVar(&concurrency, "concurrency", 4, "Number of concurrent requests")
+	benchCmd.Flags().Float64Var(&rate, "rate", 0, "Maximum number of requests per second, 0 for no limit")
+	benchCmd.Flags().IntVar(&repeat, "repeat", 1, "Number of times to replay the file")
+	benchCmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "Timeout of a request to a server")
+	benchCmd.MarkFlagRequired("file")
+
+	rootCmd.AddCommand(evalCmd, benchCmd)
+}
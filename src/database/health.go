@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tebben/geocodeur/settings"
+)
+
+// requiredExtensions are the extensions the queries of geocodeur use.
+var requiredExtensions = []string{"postgis", "pg_trgm"}
+
+// LoadInfo describes when the data of a dataset was loaded and from which Overture release. It's
+// stored as comment on the overture table so it moves along when the tables are swapped or rolled back.
+type LoadInfo struct {
+	LoadedAt time.Time `json:"loadedAt"`
+	Release  string    `json:"release,omitempty"`
+}
+
+// setLoadInfo records that the data in the tables was loaded now from the given release.
+func setLoadInfo(ctx context.Context, pool *pgxpool.Pool, tables tableSet, release string) error {
+	info, err := json.Marshal(LoadInfo{LoadedAt: time.Now().UTC(), Release: release})
+	if err != nil {
+		return err
+	}
+
+	// COMMENT does not take parameters, the JSON is quoted as literal
+	var literal string
+	err = pool.QueryRow(ctx, "SELECT quote_literal($1::text);", string(info)).Scan(&literal)
+	if err != nil {
+		return err
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf("COMMENT ON TABLE %s IS %s;", tables.overture(), literal))
+	if err != nil {
+		return fmt.Errorf("failed to record load info: %v", err)
+	}
+
+	return nil
+}
+
+// GetLoadInfo returns when the live data was loaded, it's empty for data loaded before it was recorded.
+func GetLoadInfo(ctx context.Context, pool *pgxpool.Pool, config settings.DatabaseConfig) (LoadInfo, error) {
+	var comment *string
+	err := pool.QueryRow(ctx, "SELECT obj_description(to_regclass($1), 'pg_class');", OvertureTable(config)).Scan(&comment)
+	if err != nil {
+		return LoadInfo{}, fmt.Errorf("failed to get load info: %v", err)
+	}
+
+	var info LoadInfo
+	if comment != nil {
+		// Comments that are not written by geocodeur are ignored
+		json.Unmarshal([]byte(*comment), &info)
+	}
+
+	return info, nil
+}
+
+// CheckDatabase returns the problems that keep a dataset in PostgreSQL from serving requests: the database
+// is unreachable, an extension is missing, the tables are missing or empty or the schema version is wrong.
+func CheckDatabase(ctx context.Context, pool *pgxpool.Pool, config settings.DatabaseConfig) []string {
+	err := pool.Ping(ctx)
+	if err != nil {
+		return []string{fmt.Sprintf("database is unreachable: %v", err)}
+	}
+
+	var problems []string
+	for _, extension := range requiredExtensions {
+		var installed bool
+		err = pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = $1);", extension).Scan(&installed)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("failed to check extension %s: %v", extension, err))
+		} else if !installed {
+			problems = append(problems, fmt.Sprintf("extension %s is not installed", extension))
+		}
+	}
+
+	tables := liveTables(config)
+	for _, table := range []string{tables.overture(), tables.search()} {
+		var exists, empty bool
+		err = pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL;", table).Scan(&exists)
+		if err == nil && exists {
+			err = pool.QueryRow(ctx, fmt.Sprintf("SELECT NOT EXISTS (SELECT 1 FROM %s);", table)).Scan(&empty)
+		}
+
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("failed to check table %s: %v", table, err))
+		case !exists:
+			problems = append(problems, fmt.Sprintf("table %s does not exist", table))
+		case empty:
+			problems = append(problems, fmt.Sprintf("table %s is empty", table))
+		}
+	}
+
+	err = CheckSchemaVersion(ctx, pool, config)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	return problems
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tebben/geocodeur/geometry"
+	"github.com/tebben/geocodeur/index"
+	"github.com/tebben/geocodeur/settings"
+)
+
+// BuildIndex builds an index file from the preprocessed parquet files for read-only deployments
+// without a database, see the index package for the format. Features with a geometry that cannot
+// be parsed are skipped.
+func BuildIndex(config settings.Config, path string) {
+	timeStart := time.Now()
+
+	builder, err := index.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var total loadStats
+	for _, file := range parquetFiles {
+		stats, err := loadParquetIndex(builder, config, fmt.Sprintf("%s%s", config.Process.Folder, file))
+		if err != nil {
+			builder.Abort()
+			log.Fatal(err)
+		}
+
+		total.features += stats.features
+		total.aliases += stats.aliases
+	}
+
+	if total.features == 0 || total.aliases == 0 {
+		builder.Abort()
+		log.Fatalf("No data loaded, %s is not replaced", path)
+	}
+
+	log.Info("Writing search index")
+	err = builder.Finish()
+	if err != nil {
+		log.Fatalf("Failed to write %s: %v", path, err)
+	}
+
+	log.Infof("Built %s with %d features and %d aliases in %v", path, total.features, total.aliases, time.Since(timeStart).Round(time.Second))
+}
+
+func loadParquetIndex(builder *index.Builder, config settings.Config, path string) (loadStats, error) {
+	log.Infof("Loading %s", path)
+	timeStart := time.Now()
+
+	var stats loadStats
+	skipped := 0
+	err := readRecords(path, func(rec Record) error {
+		geom, err := geometry.ParseWKT(rec.Geom)
+		if err != nil {
+			skipped++
+			return nil
+		}
+
+		divisions := []string{}
+		if rec.Relation != "" {
+			divisions = strings.Split(rec.Relation, ";")
+		}
+
+		id, err := builder.AddFeature(index.Feature{
+			Name:      rec.Name,
+			Class:     rec.Class,
+			Subclass:  rec.Subclass,
+			Divisions: divisions,
+			Geom:      geom.GeoJSON(),
+			Bounds:    geom.Bounds(),
+		})
+		if err != nil {
+			return err
+		}
+		stats.features++
+
+		for _, alias := range createAliases(config.Aliases, rec) {
+			alias = strings.ToLower(alias)
+			err = builder.AddAlias(index.Alias{
+				FeatureID:    id,
+				Alias:        alias,
+				ClassRank:    config.Ranking.ClassRank(rec.Class),
+				SubclassRank: config.Ranking.SubclassRank(rec.Subclass),
+				Importance:   rec.Importance,
+				WordCount:    len(strings.Fields(alias)),
+				CharCount:    utf8.RuneCountInString(alias),
+			})
+			if err != nil {
+				return err
+			}
+			stats.aliases++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to load %s: %v", path, err)
+	}
+
+	if skipped > 0 {
+		log.Warnf("Skipped %d features with an invalid geometry in %s", skipped, path)
+	}
+
+	duration := time.Since(timeStart)
+	log.Infof("Loaded %s: %d features and %d aliases in %v (%.0f rows/s)", path, stats.features, stats.aliases, duration.Round(time.Millisecond), float64(stats.rows())/duration.Seconds())
+
+	return stats, nil
+}
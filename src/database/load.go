@@ -0,0 +1,331 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+	"github.com/tebben/geocodeur/settings"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"golang.org/x/sync/errgroup"
+)
+
+var counter uint64
+
+// The preprocessed parquet files that are loaded into the database.
+var parquetFiles = []string{
+	"geocodeur_division.parquet",
+	"geocodeur_segment.parquet",
+	"geocodeur_water.parquet",
+	"geocodeur_poi.parquet",
+	"geocodeur_infra.parquet",
+	"geocodeur_address.parquet",
+	"geocodeur_zipcode.parquet",
+}
+
+// Number of records read from a parquet file at once and the number of rows
+// that can be queued for each COPY before the reader has to wait.
+const (
+	readBatchSize = 1000
+	copyQueueSize = 10000
+)
+
+var (
+	overtureColumns = []string{"id", "overture_id", "hash", "name", "class", "subclass", "divisions", "geom"}
+	searchColumns   = []string{"feature_id", "alias", "class_rank", "subclass_rank", "importance", "word_count", "char_count"}
+)
+
+type Record struct {
+	ID         string  `parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY, repetitiontype=OPTIONAL"`
+	Name       string  `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY, repetitiontype=OPTIONAL"`
+	Geom       string  `parquet:"name=geom, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY, repetitiontype=OPTIONAL"`
+	Class      string  `parquet:"name=class, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY, repetitiontype=OPTIONAL"`
+	Subclass   string  `parquet:"name=subclass, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY, repetitiontype=OPTIONAL"`
+	Relation   string  `parquet:"name=relation, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY, repetitiontype=OPTIONAL"`
+	Importance float64 `parquet:"name=importance, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+// loadStats contains the number of rows copied into the database.
+type loadStats struct {
+	features int64
+	aliases  int64
+}
+
+func (s loadStats) rows() int64 {
+	return s.features + s.aliases
+}
+
+// hash returns a hash of the content of the record and the fingerprint of the config, it's stored
+// with the feature to detect which features changed when updating.
+func (rec Record) hash(fingerprint string) string {
+	h := sha256.New()
+	for _, value := range []string{rec.Name, rec.Class, rec.Subclass, rec.Relation, rec.Geom, strconv.FormatFloat(rec.Importance, 'f', -1, 64), fingerprint} {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// configFingerprint returns a hash of the config the aliases and ranks of the search table are created
+// with, an update rewrites all features when it changed.
+func configFingerprint(config settings.Config) string {
+	data, _ := json.Marshal(struct {
+		Aliases       settings.AliasConfig
+		ClassRanks    map[string]int
+		SubclassRanks map[string]int
+		DefaultRank   int
+	}{config.Aliases, config.Ranking.ClassRanks, config.Ranking.SubclassRanks, config.Ranking.DefaultRank})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+func getNextID() uint64 {
+	return atomic.AddUint64(&counter, 1)
+}
+
+// loadParquet streams the records of a parquet file into the database using COPY.
+// The features are copied into the load table, since COPY cannot convert WKT to a geometry,
+// and the aliases, which are generated while reading, straight into the search table.
+func loadParquet(ctx context.Context, pool *pgxpool.Pool, tables tableSet, config settings.Config, path string) (loadStats, error) {
+	log.Infof("Loading %s", path)
+	timeStart := time.Now()
+
+	stats, err := copyParquet(ctx, pool, config, path, tables.identifier(tables.load()), tables.identifier(tables.Search), func(rec Record) (int64, bool) {
+		return int64(getNextID()), true
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	duration := time.Since(timeStart)
+	log.Infof("Loaded %s: %d features and %d aliases in %v (%.0f rows/s)", path, stats.features, stats.aliases, duration.Round(time.Millisecond), float64(stats.rows())/duration.Seconds())
+
+	return stats, nil
+}
+
+// copyParquet copies the features of a parquet file into the feature table and their aliases
+// into the alias table. The id function returns the id for a record and false to skip the record.
+func copyParquet(ctx context.Context, pool *pgxpool.Pool, config settings.Config, path string, featureTable pgx.Identifier, aliasTable pgx.Identifier, id func(rec Record) (int64, bool)) (loadStats, error) {
+	var stats loadStats
+	features := make(chan []any, copyQueueSize)
+	aliasRows := make(chan []any, copyQueueSize)
+	fingerprint := configFingerprint(config)
+
+	group, ctx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		defer close(features)
+		defer close(aliasRows)
+
+		return readRecords(path, func(rec Record) error {
+			id, ok := id(rec)
+			if !ok {
+				return nil
+			}
+
+			if err := send(ctx, features, featureRow(rec, id, fingerprint)); err != nil {
+				return err
+			}
+
+			for _, alias := range createAliases(config.Aliases, rec) {
+				if err := send(ctx, aliasRows, aliasRow(config.Ranking, rec, alias, id)); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+
+	group.Go(func() error {
+		n, err := pool.CopyFrom(ctx, featureTable, overtureColumns, copyFromChannel(ctx, features))
+		if err != nil {
+			return fmt.Errorf("failed to copy features: %v", err)
+		}
+		stats.features = n
+		return nil
+	})
+
+	group.Go(func() error {
+		n, err := pool.CopyFrom(ctx, aliasTable, searchColumns, copyFromChannel(ctx, aliasRows))
+		if err != nil {
+			return fmt.Errorf("failed to copy aliases: %v", err)
+		}
+		stats.aliases = n
+		return nil
+	})
+
+	if err := group.Wait(); err != nil {
+		return stats, fmt.Errorf("failed to load %s: %v", path, err)
+	}
+
+	return stats, nil
+}
+
+// readRecords reads all records from the parquet file and calls fn for every record with a name,
+// records without a name cannot be searched and are skipped.
+func readRecords(path string, fn func(rec Record) error) error {
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer fr.Close()
+
+	pr, err := reader.NewParquetReader(fr, new(Record), 4)
+	if err != nil {
+		return fmt.Errorf("failed to create Parquet reader: %v", err)
+	}
+	defer pr.ReadStop()
+
+	for {
+		records := make([]Record, readBatchSize)
+		if err := pr.Read(&records); err != nil {
+			return fmt.Errorf("failed to read records: %v", err)
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		for _, rec := range records {
+			if rec.Name == "" {
+				continue
+			}
+
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func send(ctx context.Context, ch chan<- []any, row []any) error {
+	select {
+	case ch <- row:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// copyFromChannel returns a CopyFromSource reading rows from the channel until it's closed.
+func copyFromChannel(ctx context.Context, ch <-chan []any) pgx.CopyFromSource {
+	return pgx.CopyFromFunc(func() ([]any, error) {
+		select {
+		case row, ok := <-ch:
+			if !ok {
+				return nil, nil
+			}
+			return row, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+}
+
+func featureRow(rec Record, id int64, fingerprint string) []any {
+	divisions := []string{}
+	if rec.Relation != "" {
+		divisions = strings.Split(rec.Relation, ";")
+	}
+
+	return []any{id, rec.ID, rec.hash(fingerprint), rec.Name, rec.Class, rec.Subclass, divisions, rec.Geom}
+}
+
+func aliasRow(ranking settings.RankingConfig, rec Record, alias string, id int64) []any {
+	alias = strings.ToLower(alias)
+	classRank := int32(ranking.ClassRank(rec.Class))
+	subclassRank := int32(ranking.SubclassRank(rec.Subclass))
+	wordCount := int32(len(strings.Fields(alias)))
+	charCount := int32(utf8.RuneCountInString(alias))
+
+	return []any{id, alias, classRank, subclassRank, float32(rec.Importance), wordCount, charCount}
+}
+
+// createAliases returns all aliases to search a record on with the configured names and truncations.
+func createAliases(aliases settings.AliasConfig, rec Record) []string {
+	// Add name as alias
+	result := []string{rec.Name}
+
+	// Add aliases for name aliases
+	for name, alias := range aliases.Names {
+		if rec.Name == name {
+			result = append(result, alias)
+		}
+	}
+
+	// Add embedding for truncated names
+	for _, truncation := range aliases.Truncations {
+		if strings.Contains(rec.Name, truncation) {
+			alias := strings.Trim(strings.Replace(rec.Name, truncation, "", 1), " ")
+			result = append(result, alias)
+		}
+	}
+
+	// Add alias for name + relation
+	if len(rec.Relation) > 0 {
+		relations := strings.Split(rec.Relation, ";")
+		for _, relation := range relations {
+			if rec.Name == relation {
+				continue
+			}
+
+			result = append(result, rec.Name+" "+relation)
+
+			// Add entry for relation aliases
+			for name, alias := range aliases.Names {
+				if relation == name {
+					result = append(result, rec.Name+" "+alias)
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// createTableLoad creates the unlogged table features are copied into before
+// their geometry is converted and they are moved into the overture table.
+func createTableLoad(ctx context.Context, pool *pgxpool.Pool, tables tableSet) error {
+	query := fmt.Sprintf(`
+		DROP TABLE IF EXISTS %[1]s;
+
+		CREATE UNLOGGED TABLE %[1]s (
+			id BIGINT,
+			overture_id TEXT,
+			hash TEXT,
+			name TEXT,
+			class TEXT,
+			subclass TEXT,
+			divisions TEXT[],
+			geom TEXT
+		) %[2]s;
+	`, tables.qualify(tables.load()), tables.tablespace())
+
+	_, err := pool.Exec(ctx, query)
+	return err
+}
+
+// moveLoadedFeatures converts the geometries of the loaded features and moves them into the overture table.
+func moveLoadedFeatures(ctx context.Context, pool *pgxpool.Pool, tables tableSet) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s (id, overture_id, hash, name, class, subclass, divisions, geom)
+		SELECT id, overture_id, hash, name, class, subclass, divisions, ST_GeomFromText(geom, 4326) FROM %[2]s;
+
+		DROP TABLE %[2]s;
+	`, tables.overture(), tables.qualify(tables.load()))
+
+	_, err := pool.Exec(ctx, query)
+	return err
+}
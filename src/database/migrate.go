@@ -0,0 +1,303 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+	"github.com/tebben/geocodeur/settings"
+)
+
+// schemaVersionTable returns the table tracking the migrations applied to a table pair, every pair
+// has its own version so datasets sharing a schema are migrated separately.
+func schemaVersionTable(tables tableSet) string {
+	return tables.Overture + "_schema_version"
+}
+
+// migration changes the layout of the overture and search tables, the SQL can use %[1]s
+// for the schema, %[2]s for the overture table and %[3]s for the search table. Migrations are applied
+// to the live and the previous tables so a rollback restores tables with the same layout.
+type migration struct {
+	Version     int
+	Description string
+	Up          string
+	Down        string
+}
+
+// migrations contains all layout changes in order, create always creates the
+// tables with the layout of the latest migration.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "Overture and search tables",
+	},
+	{
+		Version:     2,
+		Description: "Importance of features in the search table",
+		Up:          `ALTER TABLE %[1]s.%[3]s ADD COLUMN IF NOT EXISTS importance REAL DEFAULT 0;`,
+		Down:        `ALTER TABLE %[1]s.%[3]s DROP COLUMN IF EXISTS importance;`,
+	},
+	{
+		Version:     3,
+		Description: "Overture id and content hash of features",
+		Up: `
+			ALTER TABLE %[1]s.%[2]s ADD COLUMN IF NOT EXISTS overture_id TEXT;
+			ALTER TABLE %[1]s.%[2]s ADD COLUMN IF NOT EXISTS hash TEXT;
+			CREATE INDEX IF NOT EXISTS idx_%[2]s_overture_id ON %[1]s.%[2]s USING btree (overture_id);
+		`,
+		Down: `
+			DROP INDEX IF EXISTS %[1]s.idx_%[2]s_overture_id;
+			ALTER TABLE %[1]s.%[2]s DROP COLUMN IF EXISTS hash;
+			ALTER TABLE %[1]s.%[2]s DROP COLUMN IF EXISTS overture_id;
+		`,
+	},
+	{
+		Version:     4,
+		Description: "Character count of aliases in characters instead of bytes",
+		Up:          `UPDATE %[1]s.%[3]s SET char_count = char_length(alias) WHERE char_count <> char_length(alias);`,
+		Down:        `UPDATE %[1]s.%[3]s SET char_count = octet_length(alias) WHERE char_count <> octet_length(alias);`,
+	},
+}
+
+// LatestSchemaVersion returns the schema version this version of geocodeur works with.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate runs the migrate command, the action is either up, down or status.
+func Migrate(ctx context.Context, config settings.Config, action string) {
+	pool, err := GetDBPool(ctx, datasetName(config), config.Database)
+	if err != nil {
+		log.Fatalf("Failed to get database pool: %v", err)
+	}
+
+	switch action {
+	case "up":
+		err = migrateUp(ctx, pool, config.Database)
+	case "down":
+		err = migrateDown(ctx, pool, config.Database)
+	case "status":
+		err = migrateStatus(ctx, pool, config.Database)
+	default:
+		err = fmt.Errorf("unknown migrate action '%s', use up, down or status", action)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// CheckSchemaVersion returns an error when the schema version of the database
+// is not the version this version of geocodeur works with.
+func CheckSchemaVersion(ctx context.Context, pool *pgxpool.Pool, config settings.DatabaseConfig) error {
+	version, err := getSchemaVersion(ctx, pool, liveTables(config))
+	if err != nil {
+		return err
+	}
+
+	latest := LatestSchemaVersion()
+	if version < latest {
+		return fmt.Errorf("database schema version %d is older than the required version %d, run 'geocodeur migrate up' to upgrade the database", version, latest)
+	}
+
+	if version > latest {
+		return fmt.Errorf("database schema version %d is newer than version %d supported by this geocodeur, upgrade geocodeur", version, latest)
+	}
+
+	return nil
+}
+
+// getSchemaVersion returns the current schema version of the tables, 0 when the tables don't exist.
+// Tables without a recorded version were loaded before versions were tracked per table pair and
+// are at version 1, the migrations can be applied to them again.
+func getSchemaVersion(ctx context.Context, db querier, tables tableSet) (int, error) {
+	exists, err := tableExists(ctx, db, tables.qualify(schemaVersionTable(tables)))
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		exists, err = tableExists(ctx, db, tables.overture())
+		if err != nil || !exists {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	var version int
+	err = db.QueryRow(ctx, fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s;", tables.qualify(schemaVersionTable(tables)))).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %v", err)
+	}
+
+	return version, nil
+}
+
+// setSchemaVersion records that all migrations up to and including version are applied.
+func setSchemaVersion(ctx context.Context, tx pgx.Tx, tables tableSet, version int) error {
+	table := tables.qualify(schemaVersionTable(tables))
+	_, err := tx.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version INT PRIMARY KEY,
+			description TEXT,
+			applied_at TIMESTAMPTZ DEFAULT now()
+		);
+	`, table))
+	if err != nil {
+		return fmt.Errorf("failed to create schema version table: %v", err)
+	}
+
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+
+		_, err = tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s (version, description) VALUES ($1, $2) ON CONFLICT (version) DO NOTHING;", table), m.Version, m.Description)
+		if err != nil {
+			return fmt.Errorf("failed to set schema version: %v", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE version > $1;", table), version)
+	return err
+}
+
+// migrateUp applies all migrations that are not applied yet, each migration runs in its own transaction.
+// Nothing is done when the tables don't exist, create records the version when its tables go live.
+func migrateUp(ctx context.Context, pool *pgxpool.Pool, config settings.DatabaseConfig) error {
+	version, err := getSchemaVersion(ctx, pool, liveTables(config))
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		log.Infof("No tables to migrate, %s does not exist", liveTables(config).overture())
+		return nil
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		log.Infof("Applying migration %d: %s", m.Version, m.Description)
+		err = runMigration(ctx, pool, config, m.Up, m.Version)
+		if err != nil {
+			return fmt.Errorf("migration %d failed: %v", m.Version, err)
+		}
+	}
+
+	log.Infof("Database schema is at version %d", LatestSchemaVersion())
+	return nil
+}
+
+// migrateDown reverts the last applied migration.
+func migrateDown(ctx context.Context, pool *pgxpool.Pool, config settings.DatabaseConfig) error {
+	version, err := getSchemaVersion(ctx, pool, liveTables(config))
+	if err != nil {
+		return err
+	}
+
+	if version <= 1 {
+		return fmt.Errorf("database schema is at version %d and cannot be migrated down", version)
+	}
+
+	for i := len(migrations) - 1; i > 0; i-- {
+		m := migrations[i]
+		if m.Version != version {
+			continue
+		}
+
+		log.Infof("Reverting migration %d: %s", m.Version, m.Description)
+		err = runMigration(ctx, pool, config, m.Down, migrations[i-1].Version)
+		if err != nil {
+			return fmt.Errorf("reverting migration %d failed: %v", m.Version, err)
+		}
+
+		log.Infof("Database schema is at version %d", migrations[i-1].Version)
+		return nil
+	}
+
+	return fmt.Errorf("database schema version %d is unknown to this version of geocodeur", version)
+}
+
+// runMigration runs the SQL of a migration on the live and previous tables and sets
+// the schema version in one transaction.
+func runMigration(ctx context.Context, pool *pgxpool.Pool, config settings.DatabaseConfig, sql string, version int) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if sql != "" {
+		for _, tables := range []tableSet{liveTables(config), previousTables(config)} {
+			exists, err := tableExists(ctx, tx, tables.overture())
+			if err != nil {
+				return err
+			}
+
+			if !exists {
+				continue
+			}
+
+			_, err = tx.Exec(ctx, fmt.Sprintf(sql, tables.Schema, tables.Overture, tables.Search))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = setSchemaVersion(ctx, tx, liveTables(config), version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// migrateStatus logs the current schema version and the state of every migration.
+func migrateStatus(ctx context.Context, pool *pgxpool.Pool, config settings.DatabaseConfig) error {
+	tables := liveTables(config)
+	version, err := getSchemaVersion(ctx, pool, tables)
+	if err != nil {
+		return err
+	}
+
+	exists, err := tableExists(ctx, pool, tables.qualify(schemaVersionTable(tables)))
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int]time.Time)
+	if exists {
+		rows, err := pool.Query(ctx, fmt.Sprintf("SELECT version, applied_at FROM %s;", tables.qualify(schemaVersionTable(tables))))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var v int
+			var appliedAt time.Time
+			if err := rows.Scan(&v, &appliedAt); err != nil {
+				return err
+			}
+			applied[v] = appliedAt
+		}
+	}
+
+	log.Infof("Database schema version: %d, required version: %d", version, LatestSchemaVersion())
+	for _, m := range migrations {
+		if appliedAt, ok := applied[m.Version]; ok {
+			log.Infof("%3d applied %s  %s", m.Version, appliedAt.Format(time.RFC3339), m.Description)
+		} else {
+			log.Infof("%3d pending %-20s  %s", m.Version, "", m.Description)
+		}
+	}
+
+	return nil
+}
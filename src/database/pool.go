@@ -16,8 +16,19 @@ var (
 	dbPoolMutex     sync.Mutex                       // Mutex to ensure thread safety for dbPoolMap
 	poolLastUsed    = make(map[string]time.Time)     // Map to track last usage time of each pool
 	cleanupInterval = 3 * time.Minute                // Interval to check for idle pools
+	poolConnects    = make(map[string]*poolConnect)  // Pools that are being opened
 )
 
+// connectTimeout is the time a pool gets to connect to its database.
+const connectTimeout = 30 * time.Second
+
+// poolConnect is a pool that is being opened, done is closed when the pool or the error is set.
+type poolConnect struct {
+	done chan struct{}
+	pool *pgxpool.Pool
+	err  error
+}
+
 // init is called before the main function.
 // It starts a goroutine to periodically clean up idle database connection pools.
 func init() {
@@ -69,35 +80,94 @@ func CloseDBPools() {
 // If a pool with the given name already exists, it returns the existing pool.
 // Otherwise, it creates a new pool and adds it to the pool map.
 // The last used time for the pool is updated each time it is retrieved or created.
-func GetDBPool(name string, config settings.DatabaseConfig) (*pgxpool.Pool, error) {
+// Pools are opened outside the lock so a slow database doesn't block the pools of other databases,
+// requests for a pool that is being opened wait for it until their context is done.
+func GetDBPool(ctx context.Context, name string, config settings.DatabaseConfig) (*pgxpool.Pool, error) {
 	dbPoolMutex.Lock()
-	defer dbPoolMutex.Unlock()
-
 	if pool, ok := dbPoolMap[name]; ok {
 		poolLastUsed[name] = time.Now() // Update last used time
+		dbPoolMutex.Unlock()
 		return pool, nil
 	}
 
+	connect, ok := poolConnects[name]
+	if !ok {
+		connect = &poolConnect{done: make(chan struct{})}
+		poolConnects[name] = connect
+		go openDBPool(name, config, connect)
+	}
+	dbPoolMutex.Unlock()
+
+	select {
+	case <-connect.done:
+		return connect.pool, connect.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("error connecting to database '%s': %w", name, ctx.Err())
+	}
+}
+
+// openDBPool opens a pool with its own timeout instead of the context of a request, so a cancelled
+// request doesn't fail the pool for the requests waiting on it. A pool that failed to open is opened
+// again by the next request.
+func openDBPool(name string, config settings.DatabaseConfig, connect *poolConnect) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	connect.pool, connect.err = newDBPool(ctx, name, config)
+
+	dbPoolMutex.Lock()
+	delete(poolConnects, name)
+	if connect.err == nil {
+		log.Debugf("Opened new database pool: %s", name)
+		dbPoolMap[name] = connect.pool
+		poolLastUsed[name] = time.Now() // Update last used time
+	}
+	dbPoolMutex.Unlock()
+
+	close(connect.done)
+}
+
+func newDBPool(ctx context.Context, name string, config settings.DatabaseConfig) (*pgxpool.Pool, error) {
 	poolConfig, err := pgxpool.ParseConfig(config.ConnectionString)
-	poolConfig.MaxConns = config.MaxConnections
 	if err != nil {
 		return nil, fmt.Errorf("Unable to parse connection string")
 	}
+	poolConfig.MaxConns = config.MaxConnections
+	poolConfig.ConnConfig.Tracer = queryTracer{}
 
-	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to database '%s': %v", name, err)
 	}
 
-	err = pool.Ping(context.Background())
+	err = pool.Ping(ctx)
 	if err != nil {
 		pool.Close()
 		return nil, fmt.Errorf("error connecting to database '%s': %v", name, err)
 	}
 
-	log.Debugf("Opened new database pool: %s", name)
-	dbPoolMap[name] = pool
-	poolLastUsed[name] = time.Now() // Update last used time
 	return pool, nil
 }
+
+// datasetName returns the name of the dataset a command runs on, its pool is named after it so the
+// pool doesn't get mixed up with the pool of another dataset.
+func datasetName(config settings.Config) string {
+	if len(config.Datasets) > 0 {
+		return config.Datasets[0].Name
+	}
+	return config.Database.Name
+}
+
+// PoolStats returns the statistics of the open database connection pools by name.
+func PoolStats() map[string]*pgxpool.Stat {
+	dbPoolMutex.Lock()
+	defer dbPoolMutex.Unlock()
+
+	stats := make(map[string]*pgxpool.Stat, len(dbPoolMap))
+	for name, pool := range dbPoolMap {
+		stats[name] = pool.Stat()
+	}
+
+	return stats
+}
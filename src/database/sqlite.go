@@ -0,0 +1,212 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tebben/geocodeur/geometry"
+	"github.com/tebben/geocodeur/settings"
+	_ "modernc.org/sqlite"
+)
+
+// The tables of a SQLite file, a file contains a single dataset so the names are fixed.
+const (
+	SQLiteOvertureTable = "overture"
+	SQLiteSearchTable   = "overture_search"
+	SQLiteRTreeTable    = "overture_rtree"
+	SQLiteFTSTable      = "overture_search_fts"
+)
+
+// CreateSQLite creates a SQLite file from the preprocessed parquet files for small deployments
+// without PostgreSQL. Aliases are searched with FTS5 using the trigram tokenizer and the bounds of
+// the geometries are stored in an R*Tree, geometries are stored as GeoJSON. The file is written
+// next to the target and moved into place when everything is loaded.
+func CreateSQLite(config settings.Config, path string) {
+	timeStart := time.Now()
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+
+	db, err := sql.Open("sqlite", tmp)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", tmp, err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	log.Infof("Creating tables in %s", tmp)
+	err = createSQLiteTables(db)
+	if err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+	}
+
+	var total loadStats
+	for _, file := range parquetFiles {
+		stats, err := loadParquetSQLite(db, config, fmt.Sprintf("%s%s", config.Process.Folder, file))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		total.features += stats.features
+		total.aliases += stats.aliases
+	}
+
+	if total.features == 0 || total.aliases == 0 {
+		log.Fatalf("No data loaded, %s is not replaced", path)
+	}
+
+	log.Info("Creating search indexes")
+	err = createSQLiteIndexes(db)
+	if err != nil {
+		log.Fatalf("Failed to create indexes: %v", err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		log.Fatalf("Failed to close %s: %v", tmp, err)
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		log.Fatalf("Failed to move %s to %s: %v", tmp, path, err)
+	}
+
+	log.Infof("Created %s with %d features and %d aliases in %v", path, total.features, total.aliases, time.Since(timeStart).Round(time.Second))
+}
+
+func createSQLiteTables(db *sql.DB) error {
+	query := fmt.Sprintf(`
+		PRAGMA journal_mode = OFF;
+		PRAGMA synchronous = OFF;
+
+		CREATE TABLE %[1]s (
+			id INTEGER PRIMARY KEY,
+			overture_id TEXT,
+			name TEXT,
+			class TEXT,
+			subclass TEXT,
+			divisions TEXT,
+			geom TEXT
+		);
+
+		CREATE VIRTUAL TABLE %[3]s USING rtree(id, min_lon, max_lon, min_lat, max_lat);
+
+		CREATE TABLE %[2]s (
+			feature_id INTEGER,
+			alias TEXT,
+			class_rank INTEGER,
+			subclass_rank INTEGER,
+			importance REAL,
+			word_count INTEGER,
+			char_count INTEGER
+		);
+	`, SQLiteOvertureTable, SQLiteSearchTable, SQLiteRTreeTable)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// createSQLiteIndexes creates the FTS index on the aliases, it's built at once after loading since
+// that's a lot faster than updating it for every alias.
+func createSQLiteIndexes(db *sql.DB) error {
+	query := fmt.Sprintf(`
+		CREATE VIRTUAL TABLE %[2]s USING fts5(alias, content='%[1]s', tokenize='trigram');
+		INSERT INTO %[2]s (%[2]s) VALUES ('rebuild');
+		CREATE INDEX idx_%[1]s_feature_id ON %[1]s (feature_id);
+		CREATE INDEX idx_%[1]s_class_subclass ON %[1]s (class_rank, subclass_rank);
+		ANALYZE;
+	`, SQLiteSearchTable, SQLiteFTSTable)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// loadParquetSQLite inserts the features and aliases of a parquet file in one transaction,
+// features with a geometry that cannot be parsed are skipped.
+func loadParquetSQLite(db *sql.DB, config settings.Config, path string) (loadStats, error) {
+	log.Infof("Loading %s", path)
+	timeStart := time.Now()
+
+	var stats loadStats
+	tx, err := db.Begin()
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+
+	insertFeature, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (id, overture_id, name, class, subclass, divisions, geom) VALUES (?, ?, ?, ?, ?, ?, ?);", SQLiteOvertureTable))
+	if err != nil {
+		return stats, err
+	}
+
+	insertBounds, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (id, min_lon, max_lon, min_lat, max_lat) VALUES (?, ?, ?, ?, ?);", SQLiteRTreeTable))
+	if err != nil {
+		return stats, err
+	}
+
+	insertAlias, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (feature_id, alias, class_rank, subclass_rank, importance, word_count, char_count) VALUES (?, ?, ?, ?, ?, ?, ?);", SQLiteSearchTable))
+	if err != nil {
+		return stats, err
+	}
+
+	skipped := 0
+	err = readRecords(path, func(rec Record) error {
+		geom, err := geometry.ParseWKT(rec.Geom)
+		if err != nil {
+			skipped++
+			return nil
+		}
+
+		id := int64(getNextID())
+		_, err = insertFeature.Exec(id, rec.ID, rec.Name, rec.Class, rec.Subclass, rec.Relation, string(geom.GeoJSON()))
+		if err != nil {
+			return err
+		}
+
+		bounds := geom.Bounds()
+		_, err = insertBounds.Exec(id, bounds.MinLon, bounds.MaxLon, bounds.MinLat, bounds.MaxLat)
+		if err != nil {
+			return err
+		}
+		stats.features++
+
+		for _, alias := range createAliases(config.Aliases, rec) {
+			_, err = insertAlias.Exec(aliasRow(config.Ranking, rec, alias, id)...)
+			if err != nil {
+				return err
+			}
+			stats.aliases++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to load %s: %v", path, err)
+	}
+
+	if skipped > 0 {
+		log.Warnf("Skipped %d features with an invalid geometry in %s", skipped, path)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return stats, err
+	}
+
+	duration := time.Since(timeStart)
+	log.Infof("Loaded %s: %d features and %d aliases in %v (%.0f rows/s)", path, stats.features, stats.aliases, duration.Round(time.Millisecond), float64(stats.rows())/duration.Seconds())
+
+	return stats, nil
+}
+
+// SQLiteDivisions splits the divisions as stored in a SQLite file.
+func SQLiteDivisions(divisions string) []string {
+	if divisions == "" {
+		return []string{}
+	}
+
+	return strings.Split(divisions, ";")
+}
@@ -0,0 +1,287 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+	"github.com/tebben/geocodeur/settings"
+)
+
+// querier is implemented by both a pool and a transaction.
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// tableSet is an overture and search table pair that is loaded, swapped and rolled back together.
+// The names are not qualified, names of indexes and constraints are derived from them.
+type tableSet struct {
+	Schema     string
+	Tablespace string
+	Overture   string
+	Search     string
+}
+
+// OvertureTable returns the qualified name of the overture table queried by the API.
+func OvertureTable(config settings.DatabaseConfig) string {
+	return liveTables(config).overture()
+}
+
+// SearchTable returns the qualified name of the search table queried by the API.
+func SearchTable(config settings.DatabaseConfig) string {
+	return liveTables(config).search()
+}
+
+// liveTables returns the tables queried by the API.
+func liveTables(config settings.DatabaseConfig) tableSet {
+	return tableSet{Schema: config.Schema, Tablespace: config.Tablespace, Overture: config.OvertureTable, Search: config.SearchTable}
+}
+
+// stagingTables returns the tables new data is loaded into before it's swapped with the live tables.
+func stagingTables(config settings.DatabaseConfig) tableSet {
+	return liveTables(config).withSuffix("_staging")
+}
+
+// previousTables returns the tables holding the data that was live before the last swap.
+func previousTables(config settings.DatabaseConfig) tableSet {
+	return liveTables(config).withSuffix("_previous")
+}
+
+func (t tableSet) withSuffix(suffix string) tableSet {
+	t.Overture += suffix
+	t.Search += suffix
+	return t
+}
+
+// qualify returns the name of a table or index qualified with the schema.
+func (t tableSet) qualify(name string) string {
+	return fmt.Sprintf("%s.%s", t.Schema, name)
+}
+
+// identifier returns the identifier of a table in the schema, used for COPY.
+func (t tableSet) identifier(name string) pgx.Identifier {
+	return pgx.Identifier{t.Schema, name}
+}
+
+func (t tableSet) overture() string {
+	return t.qualify(t.Overture)
+}
+
+func (t tableSet) search() string {
+	return t.qualify(t.Search)
+}
+
+// tablespace returns the TABLESPACE clause for tables and indexes, empty when no tablespace is configured.
+func (t tableSet) tablespace() string {
+	if t.Tablespace == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("TABLESPACE %s", t.Tablespace)
+}
+
+// load returns the name of the unlogged table features are copied into while loading.
+func (t tableSet) load() string {
+	return t.Overture + "_load"
+}
+
+// validateTables checks if the loaded tables contain everything that was loaded.
+func validateTables(ctx context.Context, pool *pgxpool.Pool, tables tableSet, live tableSet, stats loadStats) error {
+	features, err := countRows(ctx, pool, tables.overture())
+	if err != nil {
+		return err
+	}
+
+	aliases, err := countRows(ctx, pool, tables.search())
+	if err != nil {
+		return err
+	}
+
+	if features == 0 || aliases == 0 {
+		return fmt.Errorf("no data loaded, %s has %d rows and %s has %d rows", tables.Overture, features, tables.Search, aliases)
+	}
+
+	if features != stats.features || aliases != stats.aliases {
+		return fmt.Errorf("expected %d features and %d aliases but found %d and %d", stats.features, stats.aliases, features, aliases)
+	}
+
+	exists, err := tableExists(ctx, pool, live.overture())
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		liveFeatures, err := countRows(ctx, pool, live.overture())
+		if err != nil {
+			return err
+		}
+
+		if features < liveFeatures/2 {
+			log.Warnf("Loaded %d features which is less than half of the %d live features", features, liveFeatures)
+		}
+	}
+
+	return nil
+}
+
+// swapTables makes the staging tables live in one transaction, the live tables are kept
+// as previous tables so they can be restored with Rollback.
+func swapTables(ctx context.Context, pool *pgxpool.Pool, config settings.DatabaseConfig) error {
+	tx, err := beginRename(ctx, pool)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	previous := previousTables(config)
+	_, err = tx.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s, %s CASCADE;", previous.search(), previous.overture()))
+	if err != nil {
+		return fmt.Errorf("failed to drop previous tables: %v", err)
+	}
+
+	live := liveTables(config)
+	exists, err := tableExists(ctx, tx, live.overture())
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		err = renameTables(ctx, tx, live, previous)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = renameTables(ctx, tx, stagingTables(config), live)
+	if err != nil {
+		return err
+	}
+
+	err = setSchemaVersion(ctx, tx, live, LatestSchemaVersion())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Rollback restores the tables that were live before the last create, the tables
+// that are rolled back become the previous tables so a rollback can be undone
+// by running it again.
+func Rollback(ctx context.Context, config settings.Config) {
+	pool, err := GetDBPool(ctx, datasetName(config), config.Database)
+	if err != nil {
+		log.Fatalf("Failed to get database pool: %v", err)
+	}
+
+	tx, err := beginRename(ctx, pool)
+	if err != nil {
+		log.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	live := liveTables(config.Database)
+	previous := previousTables(config.Database)
+	exists, err := tableExists(ctx, tx, previous.overture())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !exists {
+		log.Fatalf("No previous data found in %s to roll back to", previous.Overture)
+	}
+
+	temporary := live.withSuffix("_rollback")
+	for _, rename := range [][2]tableSet{{live, temporary}, {previous, live}, {temporary, previous}} {
+		err = renameTables(ctx, tx, rename[0], rename[1])
+		if err != nil {
+			log.Fatalf("Failed to roll back: %v", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Fatalf("Failed to roll back: %v", err)
+	}
+
+	log.Infof("Rolled back, previous data is live and the replaced data is kept in %s and %s", previous.Overture, previous.Search)
+}
+
+// beginRename starts the transaction to rename the live tables in. Renaming needs an exclusive lock,
+// the lock timeout keeps incoming queries from queuing behind it for too long when the tables are busy.
+// The swap or rollback can be retried when the lock times out.
+func beginRename(ctx context.Context, pool *pgxpool.Pool) (pgx.Tx, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, "SET LOCAL lock_timeout = '10s';")
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// renameTables renames the tables and their indexes and constraints, names of
+// indexes and constraints are derived from the table name and need to follow the
+// table so the next load can use the same names.
+func renameTables(ctx context.Context, tx pgx.Tx, from tableSet, to tableSet) error {
+	queries := []string{
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", from.overture(), to.Overture),
+		fmt.Sprintf("ALTER INDEX IF EXISTS %s_pkey RENAME TO %s_pkey;", from.overture(), to.Overture),
+		fmt.Sprintf("ALTER INDEX IF EXISTS %s.idx_%s_geom RENAME TO idx_%s_geom;", from.Schema, from.Overture, to.Overture),
+		fmt.Sprintf("ALTER INDEX IF EXISTS %s.idx_%s_overture_id RENAME TO idx_%s_overture_id;", from.Schema, from.Overture, to.Overture),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", from.search(), to.Search),
+		fmt.Sprintf("ALTER INDEX IF EXISTS %s.idx_%s_class_subclass RENAME TO idx_%s_class_subclass;", from.Schema, from.Search, to.Search),
+		fmt.Sprintf("ALTER INDEX IF EXISTS %s.idx_%s_trgm RENAME TO idx_%s_trgm;", from.Schema, from.Search, to.Search),
+		fmt.Sprintf("ALTER INDEX IF EXISTS %s.idx_%s_vector_search RENAME TO idx_%s_vector_search;", from.Schema, from.Search, to.Search),
+	}
+
+	for _, query := range queries {
+		_, err := tx.Exec(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %v", from.Overture, to.Overture, err)
+		}
+	}
+
+	var hasForeignKey bool
+	err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = $1 AND conrelid = to_regclass($2));",
+		fmt.Sprintf("fk_%s_feature_id", from.Search), to.search()).Scan(&hasForeignKey)
+	if err != nil {
+		return err
+	}
+
+	if hasForeignKey {
+		_, err = tx.Exec(ctx, fmt.Sprintf("ALTER TABLE %[1]s RENAME CONSTRAINT fk_%[2]s_feature_id TO fk_%[3]s_feature_id;", to.search(), from.Search, to.Search))
+		if err != nil {
+			return fmt.Errorf("failed to rename foreign key of %s: %v", to.Search, err)
+		}
+	}
+
+	return nil
+}
+
+func tableExists(ctx context.Context, db querier, table string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL;", table).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if table %s exists: %v", table, err)
+	}
+
+	return exists, nil
+}
+
+func countRows(ctx context.Context, db querier, table string) (int64, error) {
+	var count int64
+	err := db.QueryRow(ctx, fmt.Sprintf("SELECT count(*) FROM %s;", table)).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rows of %s: %v", table, err)
+	}
+
+	return count, nil
+}
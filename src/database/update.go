@@ -0,0 +1,383 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+	"github.com/tebben/geocodeur/settings"
+	"golang.org/x/sync/errgroup"
+)
+
+// updateStats contains the number of features changed by an update.
+type updateStats struct {
+	inserted int64
+	updated  int64
+	deleted  int64
+}
+
+// Update applies the differences between the preprocessed parquet files and the live tables.
+// Features are matched on their Overture id, a feature is updated when the hash of its
+// content changed, inserted when it's new and deleted when it's no longer in the files.
+// The hash includes the alias and rank config, so all features are rewritten when it changed.
+// Only the changed features and their aliases are written, all changes are applied in
+// one transaction so the API keeps serving the old or the new data. Only the live tables
+// are updated, the previous tables keep the data replaced by the last create. The update
+// tables are dropped when the update fails.
+func Update(ctx context.Context, config settings.Config) error {
+	pool, err := GetDBPool(ctx, datasetName(config), config.Database)
+	if err != nil {
+		return fmt.Errorf("failed to get database pool: %v", err)
+	}
+
+	err = CheckSchemaVersion(ctx, pool, config.Database)
+	if err != nil {
+		return err
+	}
+
+	live := liveTables(config.Database)
+
+	timeStart := time.Now()
+	defer dropUpdateTables(ctx, pool, live)
+
+	err = backfillOvertureIDs(ctx, pool, live, config.Process.Folder)
+	if err != nil {
+		return fmt.Errorf("failed to set Overture ids: %v", err)
+	}
+
+	log.Info("Reading Overture ids and hashes")
+	err = loadHashes(ctx, pool, live, config)
+	if err != nil {
+		return fmt.Errorf("failed to load hashes: %v", err)
+	}
+
+	err = checkUniqueIDs(ctx, pool, live)
+	if err != nil {
+		return err
+	}
+
+	changed, stats, err := findChanges(ctx, pool, live)
+	if err != nil {
+		return fmt.Errorf("failed to find changes: %v", err)
+	}
+
+	log.Infof("Found %d new, %d changed and %d deleted features", stats.inserted, stats.updated, stats.deleted)
+	if len(changed) == 0 && stats.deleted == 0 {
+		log.Info("Nothing to update")
+		return nil
+	}
+
+	err = copyChanges(ctx, pool, live, config, changed)
+	if err != nil {
+		return fmt.Errorf("failed to copy changes: %v", err)
+	}
+
+	log.Info("Applying changes")
+	err = applyChanges(ctx, pool, live)
+	if err != nil {
+		return fmt.Errorf("failed to apply changes: %v", err)
+	}
+
+	// The planner statistics are outdated after rewriting part of the tables
+	_, err = pool.Exec(ctx, fmt.Sprintf("ANALYZE %s, %s;", live.overture(), live.search()))
+	if err != nil {
+		return fmt.Errorf("failed to analyze tables: %v", err)
+	}
+
+	err = setLoadInfo(ctx, pool, live, config.Process.Release)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Updated in %v: %d inserted, %d updated and %d deleted features", time.Since(timeStart).Round(time.Second), stats.inserted, stats.updated, stats.deleted)
+	return nil
+}
+
+func hashTable(tables tableSet) string {
+	return tables.Overture + "_update_hashes"
+}
+
+func aliasUpdateTable(tables tableSet) string {
+	return tables.Search + "_update"
+}
+
+func legacyTable(tables tableSet) string {
+	return tables.Overture + "_update_legacy"
+}
+
+// loadHashes copies the Overture id and content hash of every record in the parquet files into the hash table.
+func loadHashes(ctx context.Context, pool *pgxpool.Pool, tables tableSet, config settings.Config) error {
+	query := fmt.Sprintf(`
+		DROP TABLE IF EXISTS %[1]s;
+		CREATE UNLOGGED TABLE %[1]s (
+			overture_id TEXT,
+			hash TEXT
+		) %[2]s;
+	`, tables.qualify(hashTable(tables)), tables.tablespace())
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	fingerprint := configFingerprint(config)
+	err = copyRecords(ctx, pool, tables.identifier(hashTable(tables)), []string{"overture_id", "hash"}, config.Process.Folder, func(rec Record) []any {
+		return []any{rec.ID, rec.hash(fingerprint)}
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf(`
+		CREATE INDEX ON %[1]s (overture_id);
+		ANALYZE %[1]s;
+	`, tables.qualify(hashTable(tables))))
+
+	return err
+}
+
+// copyRecords copies a row of every record in the parquet files into a table.
+func copyRecords(ctx context.Context, pool *pgxpool.Pool, table pgx.Identifier, columns []string, folder string, row func(rec Record) []any) error {
+	for _, file := range parquetFiles {
+		path := fmt.Sprintf("%s%s", folder, file)
+		rows := make(chan []any, copyQueueSize)
+
+		group, ctx := errgroup.WithContext(ctx)
+		group.Go(func() error {
+			defer close(rows)
+			return readRecords(path, func(rec Record) error {
+				return send(ctx, rows, row(rec))
+			})
+		})
+
+		group.Go(func() error {
+			_, err := pool.CopyFrom(ctx, table, columns, copyFromChannel(ctx, rows))
+			return err
+		})
+
+		if err := group.Wait(); err != nil {
+			return fmt.Errorf("failed to read %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// backfillOvertureIDs sets the Overture id of features loaded before the id was stored, the
+// migration can't do this since the ids are only in the parquet files. A feature gets the id of
+// the record with the same name, class, subclass and geometry so it keeps its id, the hash is left
+// empty so the feature is rewritten once by the update. Features without a matching record are
+// deleted by the update and their records inserted as new features.
+func backfillOvertureIDs(ctx context.Context, pool *pgxpool.Pool, tables tableSet, folder string) error {
+	var legacy int64
+	err := pool.QueryRow(ctx, fmt.Sprintf("SELECT count(*) FROM %s WHERE overture_id IS NULL;", tables.overture())).Scan(&legacy)
+	if err != nil || legacy == 0 {
+		return err
+	}
+
+	log.Infof("Matching %d features without Overture id", legacy)
+	_, err = pool.Exec(ctx, fmt.Sprintf(`
+		DROP TABLE IF EXISTS %[1]s;
+		CREATE UNLOGGED TABLE %[1]s (
+			overture_id TEXT,
+			name TEXT,
+			class TEXT,
+			subclass TEXT,
+			geom TEXT
+		) %[2]s;
+	`, tables.qualify(legacyTable(tables)), tables.tablespace()))
+	if err != nil {
+		return err
+	}
+
+	err = copyRecords(ctx, pool, tables.identifier(legacyTable(tables)), []string{"overture_id", "name", "class", "subclass", "geom"}, folder, func(rec Record) []any {
+		return []any{rec.ID, rec.Name, rec.Class, rec.Subclass, rec.Geom}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Every feature gets at most one Overture id and every Overture id is used at most once
+	tag, err := pool.Exec(ctx, fmt.Sprintf(`
+		UPDATE %[1]s AS o SET overture_id = m.overture_id
+		FROM (
+			SELECT DISTINCT ON (overture_id) id, overture_id
+			FROM (
+				SELECT DISTINCT ON (o.id) o.id, l.overture_id
+				FROM %[1]s AS o
+				JOIN %[2]s AS l ON l.name = o.name AND l.class = o.class AND l.subclass = o.subclass
+				WHERE o.overture_id IS NULL
+				AND ST_GeomFromText(l.geom, 4326) = o.geom
+				AND NOT EXISTS (SELECT 1 FROM %[1]s AS e WHERE e.overture_id = l.overture_id)
+				ORDER BY o.id, l.overture_id
+			) AS c
+			ORDER BY overture_id, id
+		) AS m
+		WHERE o.id = m.id;
+	`, tables.overture(), tables.qualify(legacyTable(tables))))
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Set the Overture id of %d of %d features", tag.RowsAffected(), legacy)
+	return nil
+}
+
+// checkUniqueIDs returns an error when an Overture id occurs more than once in the parquet files
+// or the live table, features are matched on their Overture id so every id has to be unique.
+func checkUniqueIDs(ctx context.Context, pool *pgxpool.Pool, tables tableSet) error {
+	for _, table := range []string{tables.qualify(hashTable(tables)), tables.overture()} {
+		var overtureID string
+		var count int64
+		err := pool.QueryRow(ctx, fmt.Sprintf(`
+			SELECT overture_id, count(*) FROM %s
+			WHERE overture_id IS NOT NULL
+			GROUP BY overture_id HAVING count(*) > 1
+			LIMIT 1;
+		`, table)).Scan(&overtureID, &count)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("overture id %s occurs %d times in %s, update needs one feature per Overture id, use create instead", overtureID, count, table)
+	}
+
+	return nil
+}
+
+// findChanges returns the Overture ids of the new and changed features mapped to the id
+// they will get in the overture table, changed features keep their id.
+func findChanges(ctx context.Context, pool *pgxpool.Pool, tables tableSet) (map[string]int64, updateStats, error) {
+	var stats updateStats
+
+	var maxID int64
+	err := pool.QueryRow(ctx, fmt.Sprintf("SELECT COALESCE(MAX(id), 0) FROM %s;", tables.overture())).Scan(&maxID)
+	if err != nil {
+		return nil, stats, err
+	}
+	atomic.StoreUint64(&counter, uint64(maxID))
+
+	rows, err := pool.Query(ctx, fmt.Sprintf(`
+		SELECT u.overture_id, o.id
+		FROM %[1]s AS u
+		LEFT JOIN %[2]s AS o ON o.overture_id = u.overture_id
+		WHERE o.id IS NULL OR o.hash IS DISTINCT FROM u.hash;
+	`, tables.qualify(hashTable(tables)), tables.overture()))
+	if err != nil {
+		return nil, stats, err
+	}
+	defer rows.Close()
+
+	changed := make(map[string]int64)
+	for rows.Next() {
+		var overtureID string
+		var id *int64
+		if err := rows.Scan(&overtureID, &id); err != nil {
+			return nil, stats, err
+		}
+
+		if id == nil {
+			changed[overtureID] = int64(getNextID())
+			stats.inserted++
+		} else {
+			changed[overtureID] = *id
+			stats.updated++
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, stats, err
+	}
+
+	err = pool.QueryRow(ctx, fmt.Sprintf(`
+		SELECT count(*) FROM %[2]s AS o
+		WHERE NOT EXISTS (SELECT 1 FROM %[1]s AS u WHERE u.overture_id = o.overture_id);
+	`, tables.qualify(hashTable(tables)), tables.overture())).Scan(&stats.deleted)
+
+	return changed, stats, err
+}
+
+// copyChanges copies the new and changed features and their aliases into the update tables.
+func copyChanges(ctx context.Context, pool *pgxpool.Pool, tables tableSet, config settings.Config, changed map[string]int64) error {
+	err := createTableLoad(ctx, pool, tables)
+	if err != nil {
+		return err
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf(`
+		DROP TABLE IF EXISTS %[1]s;
+		CREATE UNLOGGED TABLE %[1]s (
+			feature_id BIGINT,
+			alias TEXT,
+			class_rank INT,
+			subclass_rank INT,
+			importance REAL,
+			word_count INT,
+			char_count INT
+		) %[2]s;
+	`, tables.qualify(aliasUpdateTable(tables)), tables.tablespace()))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range parquetFiles {
+		path := fmt.Sprintf("%s%s", config.Process.Folder, file)
+		stats, err := copyParquet(ctx, pool, config, path, tables.identifier(tables.load()), tables.identifier(aliasUpdateTable(tables)), func(rec Record) (int64, bool) {
+			id, ok := changed[rec.ID]
+			return id, ok
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Copied %d changed features and %d aliases from %s", stats.features, stats.aliases, path)
+	}
+
+	return nil
+}
+
+// applyChanges deletes the removed and changed features, which cascades to their aliases,
+// and inserts the new and changed features and aliases in one transaction.
+func applyChanges(ctx context.Context, pool *pgxpool.Pool, tables tableSet) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := fmt.Sprintf(`
+		DELETE FROM %[1]s AS o
+		WHERE NOT EXISTS (SELECT 1 FROM %[3]s AS u WHERE u.overture_id = o.overture_id);
+
+		DELETE FROM %[1]s
+		WHERE id IN (SELECT id FROM %[4]s);
+
+		INSERT INTO %[1]s (id, overture_id, hash, name, class, subclass, divisions, geom)
+		SELECT id, overture_id, hash, name, class, subclass, divisions, ST_GeomFromText(geom, 4326) FROM %[4]s;
+
+		INSERT INTO %[2]s (feature_id, alias, class_rank, subclass_rank, importance, word_count, char_count, vector_search)
+		SELECT feature_id, alias, class_rank, subclass_rank, importance, word_count, char_count, to_tsvector('simple', alias) FROM %[5]s;
+	`, tables.overture(), tables.search(), tables.qualify(hashTable(tables)), tables.qualify(tables.load()), tables.qualify(aliasUpdateTable(tables)))
+
+	_, err = tx.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func dropUpdateTables(ctx context.Context, pool *pgxpool.Pool, tables tableSet) {
+	_, err := pool.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s, %s, %s, %s;", tables.qualify(hashTable(tables)), tables.qualify(legacyTable(tables)), tables.qualify(tables.load()), tables.qualify(aliasUpdateTable(tables))))
+	if err != nil {
+		log.Warnf("Failed to drop update tables: %v", err)
+	}
+}
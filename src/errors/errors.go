@@ -1,30 +1,106 @@
 package errors
 
-import "net/http"
+import (
+	"net/http"
+	"strings"
 
-// APIError represents an error returned by the API.
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Codes of the API errors, unlike the messages they don't change between versions so clients can handle them.
+const (
+	CodeInvalidInput = "invalid_input"
+	CodeNotFound     = "not_found"
+	CodeUnavailable  = "unavailable"
+	CodeTimeout      = "timeout"
+	CodeInternal     = "internal"
+	// CodeCancelled is logged for requests the client closed, the client doesn't receive the response.
+	CodeCancelled = "cancelled"
+)
+
+// StatusClientClosedRequest is the status of requests the client closed before the response was sent.
+const StatusClientClosedRequest = 499
+
+// APIError represents an error returned by the API as RFC 7807 problem details with a stable error code.
 type APIError struct {
-	StatusCode int     `json:"status"`            // The HTTP status code of the error.
-	StatusText string  `json:"statusText"`        // The HTTP status text of the error.
-	Message    string  `json:"error"`             // The error message.
-	Details    *string `json:"details,omitempty"` // Additional details about the error (optional).
+	Type     string              `json:"type,omitempty" format:"uri" default:"about:blank" doc:"A URI reference to human-readable documentation for the error"`
+	Title    string              `json:"title" example:"Bad Request" doc:"The HTTP status text of the error"`
+	Status   int                 `json:"status" example:"400" doc:"The HTTP status code of the error"`
+	Code     string              `json:"code" example:"invalid_input" doc:"The kind of error, it doesn't change between versions"`
+	Detail   string              `json:"detail,omitempty" example:"invalid latitude in point 5.29,91" doc:"Explanation of this occurrence of the error"`
+	Instance string              `json:"instance,omitempty" format:"uri" doc:"A URI reference of this occurrence of the error"`
+	Errors   []*huma.ErrorDetail `json:"errors,omitempty" doc:"The invalid parameters when the request is invalid"`
 }
 
-// Implement the Error method to satisfy the error interface
+// Error returns the detail of the error.
 func (e *APIError) Error() string {
-	return e.Message
+	return e.Detail
+}
+
+// GetStatus returns the HTTP status of the error.
+func (e *APIError) GetStatus() int {
+	return e.Status
 }
 
-// NewAPIError creates a new APIError with the given status code, test, message, and details
-func NewAPIError(statusCode int, message string, details *string) *APIError {
-	if message == "" {
-		message = http.StatusText(statusCode)
+// ContentType responds with application/problem+json instead of application/json.
+func (e *APIError) ContentType(ct string) string {
+	if ct == "application/json" {
+		return "application/problem+json"
+	}
+	return ct
+}
+
+// NewAPIError creates a new APIError with the given status code, error code and detail.
+func NewAPIError(statusCode int, code string, detail string) *APIError {
+	title := http.StatusText(statusCode)
+	if statusCode == StatusClientClosedRequest {
+		title = "Client Closed Request"
 	}
 
 	return &APIError{
-		StatusCode: statusCode,
-		StatusText: http.StatusText(statusCode),
-		Message:    message,
-		Details:    details,
+		Title:  title,
+		Status: statusCode,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// NewHumaError creates the APIError for the errors huma responds with, like invalid parameters, the code
+// is derived from the status. It replaces huma.NewError so all errors are formatted the same.
+func NewHumaError(status int, msg string, errs ...error) huma.StatusError {
+	apiError := NewAPIError(status, statusCode(status), msg)
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		if detailer, ok := err.(huma.ErrorDetailer); ok {
+			apiError.Errors = append(apiError.Errors, detailer.ErrorDetail())
+		} else {
+			apiError.Errors = append(apiError.Errors, &huma.ErrorDetail{Message: err.Error()})
+		}
+	}
+
+	return apiError
+}
+
+// statusCode returns the error code of a status, the snake cased status text for statuses without a code.
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return CodeInvalidInput
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	case http.StatusInternalServerError:
+		return CodeInternal
+	}
+
+	if text := http.StatusText(status); text != "" {
+		return strings.ReplaceAll(strings.ToLower(text), " ", "_")
 	}
+	return CodeInternal
 }
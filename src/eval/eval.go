@@ -0,0 +1,230 @@
+package eval
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tebben/geocodeur/geometry"
+	"github.com/tebben/geocodeur/service"
+)
+
+// defaultRadiusKm is the distance to the expected location a result can be at when no radius is given.
+const defaultRadiusKm = 1.0
+
+// minLimit is the largest rank a metric is reported at, fewer results would understate recall@5.
+const minLimit = 5
+
+// GoldQuery is a query of a gold file with the feature it's expected to find. A result is
+// the expected feature when it matches every expectation that is set, names and classes are
+// compared case insensitive and a location matches when the bounds of the result geometry are
+// within the radius around it.
+type GoldQuery struct {
+	Query    string   `json:"query"`
+	Name     string   `json:"name,omitempty"`
+	Class    string   `json:"class,omitempty"`
+	Subclass string   `json:"subclass,omitempty"`
+	Lon      *float64 `json:"lon,omitempty"`
+	Lat      *float64 `json:"lat,omitempty"`
+	RadiusKm float64  `json:"radiusKm,omitempty"`
+}
+
+// Metrics are the search quality metrics of a set of queries, a query is found at rank 0 when
+// the expected feature is not in the results.
+type Metrics struct {
+	Queries   int     `json:"queries"`
+	RecallAt1 float64 `json:"recallAt1"`
+	RecallAt5 float64 `json:"recallAt5"`
+	MRR       float64 `json:"mrr"`
+}
+
+// QueryResult is the rank of the expected feature of a gold query.
+type QueryResult struct {
+	Query string `json:"query"`
+	Class string `json:"class"`
+	Rank  int    `json:"rank"`
+	Top   string `json:"top"`
+}
+
+// Report is the result of an evaluation, it's written as JSON to compare later runs against.
+type Report struct {
+	Created time.Time          `json:"created"`
+	Dataset string             `json:"dataset"`
+	Limit   uint16             `json:"limit"`
+	Total   Metrics            `json:"total"`
+	Classes map[string]Metrics `json:"classes"`
+	Queries []QueryResult      `json:"queries"`
+}
+
+// ReadGold reads a gold file with a JSON gold query on every line, empty lines and
+// lines starting with # are skipped.
+func ReadGold(path string) ([]GoldQuery, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening gold file: %v", err)
+	}
+	defer f.Close()
+
+	var queries []GoldQuery
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var query GoldQuery
+		if err := json.Unmarshal([]byte(text), &query); err != nil {
+			return nil, fmt.Errorf("error reading %s line %d: %v", path, line, err)
+		}
+
+		if query.Query == "" {
+			return nil, fmt.Errorf("error reading %s line %d: query is empty", path, line)
+		}
+
+		if (query.Lon == nil) != (query.Lat == nil) {
+			return nil, fmt.Errorf("error reading %s line %d: lon and lat have to be set together", path, line)
+		}
+
+		queries = append(queries, query)
+	}
+
+	return queries, scanner.Err()
+}
+
+// Run geocodes every gold query in the dataset and reports the rank of the expected features. At least
+// 5 results are searched for the expected feature, the report contains the limit that is used.
+func Run(ctx context.Context, dataset *service.Dataset, queries []GoldQuery, threshold float64, limit uint16) (Report, error) {
+	limit = max(limit, minLimit)
+	report := Report{
+		Created: time.Now().UTC(),
+		Dataset: dataset.Name,
+		Limit:   limit,
+		Classes: make(map[string]Metrics),
+	}
+
+	for _, query := range queries {
+		options := service.NewGeocodeOptions(threshold, limit, nil, query.Lon != nil, false)
+		response, err := service.Geocode(ctx, dataset, options, query.Query)
+		if err != nil {
+			return report, fmt.Errorf("error geocoding '%s': %v", query.Query, err)
+		}
+
+		result := QueryResult{Query: query.Query, Class: query.class()}
+		for i, r := range response.Results {
+			if i == 0 {
+				result.Top = r.Name
+			}
+
+			if query.matches(r) {
+				result.Rank = i + 1
+				break
+			}
+		}
+
+		report.Queries = append(report.Queries, result)
+	}
+
+	report.Total = metrics(report.Queries)
+	byClass := make(map[string][]QueryResult)
+	for _, result := range report.Queries {
+		byClass[result.Class] = append(byClass[result.Class], result)
+	}
+
+	for class, results := range byClass {
+		report.Classes[class] = metrics(results)
+	}
+
+	return report, nil
+}
+
+func (q GoldQuery) class() string {
+	if q.Class == "" {
+		return "any"
+	}
+	return strings.ToLower(q.Class)
+}
+
+func (q GoldQuery) matches(result service.GeocodeResult) bool {
+	if q.Name != "" && !strings.EqualFold(q.Name, result.Name) {
+		return false
+	}
+
+	if q.Class != "" && !strings.EqualFold(q.Class, result.Class) {
+		return false
+	}
+
+	if q.Subclass != "" && !strings.EqualFold(q.Subclass, result.Subclass) {
+		return false
+	}
+
+	if q.Lon != nil {
+		geom, err := geometry.ParseGeoJSON(result.Geom)
+		if err != nil {
+			return false
+		}
+
+		radius := q.RadiusKm
+		if radius == 0 {
+			radius = defaultRadiusKm
+		}
+
+		if geom.Bounds().DistanceKm(*q.Lon, *q.Lat) > radius {
+			return false
+		}
+	}
+
+	return true
+}
+
+func metrics(results []QueryResult) Metrics {
+	m := Metrics{Queries: len(results)}
+	if len(results) == 0 {
+		return m
+	}
+
+	for _, result := range results {
+		if result.Rank == 0 {
+			continue
+		}
+
+		if result.Rank == 1 {
+			m.RecallAt1++
+		}
+		if result.Rank <= minLimit {
+			m.RecallAt5++
+		}
+		m.MRR += 1 / float64(result.Rank)
+	}
+
+	n := float64(len(results))
+	m.RecallAt1 /= n
+	m.RecallAt5 /= n
+	m.MRR /= n
+
+	return m
+}
+
+// classNames returns the classes of the reports sorted by name.
+func classNames(reports ...Report) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, report := range reports {
+		for class := range report.Classes {
+			if !seen[class] {
+				seen[class] = true
+				names = append(names, class)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
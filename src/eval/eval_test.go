@@ -0,0 +1,209 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tebben/geocodeur/geometry"
+	"github.com/tebben/geocodeur/service"
+	"github.com/tebben/geocodeur/settings"
+)
+
+func testDataset(t *testing.T) *service.Dataset {
+	t.Helper()
+
+	ranking := settings.RankingConfig{
+		Weights:     settings.RankingWeights{Similarity: 1},
+		DefaultRank: 100,
+		ClassRanks:  map[string]int{"division": 1, "road": 2},
+	}
+
+	vught := geometry.Bounds{MinLon: 5.29, MinLat: 51.65, MaxLon: 5.30, MaxLat: 51.66}
+	amsterdam := geometry.Bounds{MinLon: 4.88, MinLat: 52.36, MaxLon: 4.89, MaxLat: 52.37}
+	point := func(lon, lat string) json.RawMessage {
+		return json.RawMessage(`{"type":"Point","coordinates":[` + lon + `,` + lat + `]}`)
+	}
+
+	store := service.NewMemoryStore(ranking)
+	store.Add(service.Feature{ID: 1, Name: "Kerkstraat", Class: "road", Subclass: "residential", Divisions: []string{"Vught"}, Geom: point("5.295", "51.655"), Bounds: vught}, 0.5, "Kerkstraat Vught", "Kerkstraat")
+	store.Add(service.Feature{ID: 2, Name: "Kerkstraat", Class: "road", Subclass: "residential", Divisions: []string{"Amsterdam"}, Geom: point("4.885", "52.365"), Bounds: amsterdam}, 0, "Kerkstraat Amsterdam", "Kerkstraat")
+	store.Add(service.Feature{ID: 3, Name: "Vught", Class: "division", Subclass: "locality", Geom: point("5.295", "51.655"), Bounds: vught}, 0, "Vught")
+
+	dataset := &service.Dataset{DatasetConfig: settings.DatasetConfig{Name: "test"}, Store: store, Ranking: ranking}
+	if err := service.LoadVocabulary(dataset); err != nil {
+		t.Fatal(err)
+	}
+
+	return dataset
+}
+
+func writeGold(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "gold.jsonl")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadGold(t *testing.T) {
+	queries, err := ReadGold(writeGold(t, `# comment
+
+{"query": "kerkstraat", "class": "road", "lon": 4.885, "lat": 52.365}
+{"query": "vught", "name": "Vught"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(queries) != 2 || queries[0].Query != "kerkstraat" || *queries[0].Lon != 4.885 || queries[1].Name != "Vught" {
+		t.Errorf("ReadGold() = %+v", queries)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"invalid json", `{"query": }`, "line 1"},
+		{"empty query", "\n" + `{"name": "Vught"}`, "line 2: query is empty"},
+		{"lon without lat", `{"query": "vught", "lon": 5.29}`, "lon and lat have to be set together"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadGold(writeGold(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ReadGold() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	dataset := testDataset(t)
+	amsterdam := 4.885
+	amsterdamLat := 52.365
+	queries := []GoldQuery{
+		{Query: "kerkstraat", Name: "kerkstraat", Class: "road", Lon: &amsterdam, Lat: &amsterdamLat},
+		{Query: "vught", Class: "Division"},
+		{Query: "zzzzzz", Name: "Nowhere"},
+	}
+
+	tests := []struct {
+		name  string
+		limit uint16
+		want  uint16
+	}{
+		{"limit below recall@5", 1, 5},
+		{"limit above recall@5", 10, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Run(context.Background(), dataset, queries, 0.3, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Limit != tt.want {
+				t.Errorf("report limit = %d, want %d", report.Limit, tt.want)
+			}
+
+			ranks := map[string]int{}
+			for _, q := range report.Queries {
+				ranks[q.Query] = q.Rank
+			}
+			if want := map[string]int{"kerkstraat": 2, "vught": 1, "zzzzzz": 0}; !equalRanks(ranks, want) {
+				t.Errorf("ranks = %v, want %v", ranks, want)
+			}
+
+			if want := (Metrics{Queries: 3, RecallAt1: 1.0 / 3, RecallAt5: 2.0 / 3, MRR: 0.5}); report.Total != want {
+				t.Errorf("total = %+v, want %+v", report.Total, want)
+			}
+
+			if want := (Metrics{Queries: 1, RecallAt1: 0, RecallAt5: 1, MRR: 0.5}); report.Classes["road"] != want {
+				t.Errorf("road = %+v, want %+v", report.Classes["road"], want)
+			}
+
+			if want := (Metrics{Queries: 1, RecallAt1: 1, RecallAt5: 1, MRR: 1}); report.Classes["division"] != want {
+				t.Errorf("division = %+v, want %+v", report.Classes["division"], want)
+			}
+
+			if want := (Metrics{Queries: 1}); report.Classes["any"] != want {
+				t.Errorf("any = %+v, want %+v", report.Classes["any"], want)
+			}
+		})
+	}
+}
+
+func equalRanks(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for query, rank := range a {
+		if b[query] != rank {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReportRoundTrip(t *testing.T) {
+	report, err := Run(context.Background(), testDataset(t), []GoldQuery{{Query: "vught", Name: "vught"}}, 0.3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := WriteReport(path, report); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !read.Created.Equal(report.Created) || read.Limit != report.Limit || read.Total != report.Total || len(read.Queries) != 1 {
+		t.Errorf("ReadReport() = %+v, want %+v", read, report)
+	}
+}
+
+func TestPrintCompare(t *testing.T) {
+	previous := Report{
+		Total:   Metrics{Queries: 3, RecallAt1: 1, RecallAt5: 1, MRR: 1},
+		Classes: map[string]Metrics{"road": {Queries: 3, RecallAt1: 1, RecallAt5: 1, MRR: 1}},
+		Queries: []QueryResult{{Query: "a", Rank: 1}, {Query: "b", Rank: 3}, {Query: "c", Rank: 1}},
+	}
+	report := Report{
+		Total:   Metrics{Queries: 3, RecallAt1: 0.5, RecallAt5: 1, MRR: 0.75},
+		Classes: map[string]Metrics{"road": {Queries: 3, RecallAt1: 0.5, RecallAt5: 1, MRR: 0.75}},
+		Queries: []QueryResult{{Query: "a", Rank: 0, Top: "x"}, {Query: "b", Rank: 1, Top: "b"}, {Query: "c", Rank: 1, Top: "c"}},
+	}
+
+	var out bytes.Buffer
+	Print(&out, report, &previous)
+
+	for _, want := range []string{
+		"0.500 (-0.500)",
+		"1 queries regressed, 1 improved",
+		"a: #1 -> not found (top: x)",
+		"b: #3 -> #1 (top: b)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), "c: ") {
+		t.Errorf("output lists unchanged query c:\n%s", out.String())
+	}
+}
@@ -0,0 +1,112 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// WriteReport writes a report as JSON.
+func WriteReport(path string, report Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadReport reads a report written by WriteReport.
+func ReadReport(path string) (Report, error) {
+	var report Report
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, fmt.Errorf("error reading report: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("error reading report %s: %v", path, err)
+	}
+
+	return report, nil
+}
+
+// Print writes the metrics of a report in total and per class. When a previous report is given
+// the differences with it are added and the queries that ranked differently are listed.
+func Print(w io.Writer, report Report, previous *Report) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "class\tqueries\trecall@1\trecall@5\tmrr\t")
+
+	row := func(name string, m Metrics, old Metrics, compare bool) {
+		if !compare {
+			fmt.Fprintf(tw, "%s\t%d\t%.3f\t%.3f\t%.3f\t\n", name, m.Queries, m.RecallAt1, m.RecallAt5, m.MRR)
+			return
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%.3f (%+.3f)\t%.3f (%+.3f)\t%.3f (%+.3f)\t\n", name, m.Queries,
+			m.RecallAt1, m.RecallAt1-old.RecallAt1, m.RecallAt5, m.RecallAt5-old.RecallAt5, m.MRR, m.MRR-old.MRR)
+	}
+
+	for _, class := range classNames(report) {
+		old, ok := Metrics{}, false
+		if previous != nil {
+			old, ok = previous.Classes[class]
+		}
+		row(class, report.Classes[class], old, ok)
+	}
+
+	if previous != nil {
+		row("total", report.Total, previous.Total, true)
+	} else {
+		row("total", report.Total, Metrics{}, false)
+	}
+	tw.Flush()
+
+	if previous == nil {
+		return
+	}
+
+	ranks := make(map[string]int, len(previous.Queries))
+	for _, q := range previous.Queries {
+		ranks[q.Query] = q.Rank
+	}
+
+	var regressed, improved []string
+	for _, q := range report.Queries {
+		old, ok := ranks[q.Query]
+		if !ok || old == q.Rank {
+			continue
+		}
+
+		change := fmt.Sprintf("  %s: %s -> %s (top: %s)", q.Query, rankString(old), rankString(q.Rank), q.Top)
+		if q.Rank == 0 || old != 0 && q.Rank > old {
+			regressed = append(regressed, change)
+		} else {
+			improved = append(improved, change)
+		}
+	}
+
+	fmt.Fprintf(w, "\nCompared to the report of %s: %d queries regressed, %d improved\n", previous.Created.Format("2006-01-02 15:04"), len(regressed), len(improved))
+	for _, title := range []struct {
+		name    string
+		changes []string
+	}{{"Regressed", regressed}, {"Improved", improved}} {
+		if len(title.changes) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s:\n", title.name)
+		for _, change := range title.changes {
+			fmt.Fprintln(w, change)
+		}
+	}
+}
+
+func rankString(rank int) string {
+	if rank == 0 {
+		return "not found"
+	}
+	return fmt.Sprintf("#%d", rank)
+}
@@ -0,0 +1,301 @@
+package geometry
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Geometry is a geometry parsed from WKT or GeoJSON, it's used by the storage backends without
+// PostGIS to write GeoJSON and calculate bounds and distances.
+type Geometry struct {
+	Type        string     `json:"type"`
+	Coordinates any        `json:"coordinates,omitempty"`
+	Geometries  []Geometry `json:"geometries,omitempty"`
+}
+
+// Bounds is the bounding box of a geometry in WGS84.
+type Bounds struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+var geometryTypes = map[string]string{
+	"POINT":              "Point",
+	"LINESTRING":         "LineString",
+	"POLYGON":            "Polygon",
+	"MULTIPOINT":         "MultiPoint",
+	"MULTILINESTRING":    "MultiLineString",
+	"MULTIPOLYGON":       "MultiPolygon",
+	"GEOMETRYCOLLECTION": "GeometryCollection",
+}
+
+// ParseWKT parses a 2D WKT geometry, Z and M values are dropped.
+func ParseWKT(wkt string) (Geometry, error) {
+	p := &wktParser{input: wkt}
+	g, err := p.geometry()
+	if err != nil {
+		return Geometry{}, fmt.Errorf("invalid WKT: %v", err)
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return Geometry{}, fmt.Errorf("invalid WKT: unexpected %q at %d", p.input[p.pos:], p.pos)
+	}
+
+	return g, nil
+}
+
+// ParseGeoJSON parses a GeoJSON geometry.
+func ParseGeoJSON(geojson json.RawMessage) (Geometry, error) {
+	var g Geometry
+	if err := json.Unmarshal(geojson, &g); err != nil {
+		return Geometry{}, fmt.Errorf("invalid GeoJSON: %v", err)
+	}
+
+	return g, nil
+}
+
+// GeoJSON returns the geometry as GeoJSON.
+func (g Geometry) GeoJSON() json.RawMessage {
+	b, _ := json.Marshal(g)
+	return b
+}
+
+// Bounds returns the bounding box of the geometry.
+func (g Geometry) Bounds() Bounds {
+	b := Bounds{MinLon: math.Inf(1), MinLat: math.Inf(1), MaxLon: math.Inf(-1), MaxLat: math.Inf(-1)}
+	g.extend(&b)
+	return b
+}
+
+func (g Geometry) extend(b *Bounds) {
+	for _, child := range g.Geometries {
+		child.extend(b)
+	}
+
+	extendCoordinates(g.Coordinates, b)
+}
+
+func extendCoordinates(coordinates any, b *Bounds) {
+	switch c := coordinates.(type) {
+	case []float64:
+		b.MinLon = math.Min(b.MinLon, c[0])
+		b.MinLat = math.Min(b.MinLat, c[1])
+		b.MaxLon = math.Max(b.MaxLon, c[0])
+		b.MaxLat = math.Max(b.MaxLat, c[1])
+	case []any:
+		// Positions of parsed GeoJSON are arrays of numbers
+		if len(c) >= 2 {
+			lon, okLon := c[0].(float64)
+			lat, okLat := c[1].(float64)
+			if okLon && okLat {
+				extendCoordinates([]float64{lon, lat}, b)
+				return
+			}
+		}
+
+		for _, child := range c {
+			extendCoordinates(child, b)
+		}
+	}
+}
+
+// DistanceKm returns the great circle distance in kilometers from the point to
+// the closest point of the bounding box, 0 when the point is inside.
+func (b Bounds) DistanceKm(lon float64, lat float64) float64 {
+	closestLon := math.Max(b.MinLon, math.Min(lon, b.MaxLon))
+	closestLat := math.Max(b.MinLat, math.Min(lat, b.MaxLat))
+	return haversineKm(lon, lat, closestLon, closestLat)
+}
+
+func haversineKm(lon1, lat1, lon2, lat2 float64) float64 {
+	const earthRadiusKm = 6371.0088
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+type wktParser struct {
+	input string
+	pos   int
+}
+
+func (p *wktParser) geometry() (Geometry, error) {
+	word := strings.ToUpper(p.word())
+	geometryType, ok := geometryTypes[word]
+	if !ok {
+		return Geometry{}, fmt.Errorf("unsupported geometry type %q", word)
+	}
+
+	// Skip the dimension, only x and y are used
+	dimension := strings.ToUpper(p.word())
+	if dimension != "" && dimension != "Z" && dimension != "M" && dimension != "ZM" {
+		return Geometry{}, fmt.Errorf("unexpected %q after %s", dimension, word)
+	}
+
+	g := Geometry{Type: geometryType}
+	if p.empty() {
+		if geometryType == "GeometryCollection" {
+			g.Geometries = []Geometry{}
+		} else {
+			g.Coordinates = []any{}
+		}
+		return g, nil
+	}
+
+	var err error
+	switch geometryType {
+	case "Point":
+		g.Coordinates, err = p.nested(0)
+		if c, ok := g.Coordinates.([]any); ok && err == nil {
+			g.Coordinates = c[0]
+		}
+	case "LineString", "MultiPoint":
+		g.Coordinates, err = p.nested(1)
+	case "Polygon", "MultiLineString":
+		g.Coordinates, err = p.nested(2)
+	case "MultiPolygon":
+		g.Coordinates, err = p.nested(3)
+	case "GeometryCollection":
+		g.Geometries, err = p.collection()
+	}
+
+	return g, err
+}
+
+// nested parses a parenthesized list of coordinates nested depth times, a depth of
+// 0 parses a list of positions. Points in a MULTIPOINT may be written with or without parentheses.
+func (p *wktParser) nested(depth int) (any, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+
+	var items []any
+	for {
+		p.skipSpace()
+		var item any
+		var err error
+		if depth == 0 || (depth == 1 && !p.peek('(')) {
+			item, err = p.position()
+		} else if depth == 1 {
+			var point any
+			point, err = p.nested(0)
+			if err == nil {
+				item = point.([]any)[0]
+			}
+		} else {
+			item, err = p.nested(depth - 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+		if !p.accept(',') {
+			break
+		}
+	}
+
+	return items, p.expect(')')
+}
+
+func (p *wktParser) collection() ([]Geometry, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+
+	var geometries []Geometry
+	for {
+		g, err := p.geometry()
+		if err != nil {
+			return nil, err
+		}
+
+		geometries = append(geometries, g)
+		if !p.accept(',') {
+			break
+		}
+	}
+
+	return geometries, p.expect(')')
+}
+
+func (p *wktParser) position() ([]float64, error) {
+	var values []float64
+	for {
+		p.skipSpace()
+		start := p.pos
+		for p.pos < len(p.input) && strings.IndexByte("+-.0123456789eE", p.input[p.pos]) >= 0 {
+			p.pos++
+		}
+		if start == p.pos {
+			break
+		}
+
+		value, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	if len(values) < 2 {
+		return nil, fmt.Errorf("expected coordinates at %d", p.pos)
+	}
+
+	return values[:2], nil
+}
+
+func (p *wktParser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] >= 'A' && p.input[p.pos] <= 'Z' || p.input[p.pos] >= 'a' && p.input[p.pos] <= 'z') {
+		p.pos++
+	}
+
+	return p.input[start:p.pos]
+}
+
+func (p *wktParser) empty() bool {
+	p.skipSpace()
+	if strings.HasPrefix(strings.ToUpper(p.input[p.pos:]), "EMPTY") {
+		p.pos += len("EMPTY")
+		return true
+	}
+
+	return false
+}
+
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\r\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *wktParser) peek(c byte) bool {
+	p.skipSpace()
+	return p.pos < len(p.input) && p.input[p.pos] == c
+}
+
+func (p *wktParser) accept(c byte) bool {
+	if p.peek(c) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *wktParser) expect(c byte) error {
+	if !p.accept(c) {
+		return fmt.Errorf("expected '%c' at %d", c, p.pos)
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tebben/geocodeur/text"
+)
+
+// Builder writes an index file. Strings are written to the file while features and aliases are
+// added, the other sections are kept in memory and written by Finish. The file is written next
+// to the target and moved into place when it's complete.
+type Builder struct {
+	path     string
+	file     *os.File
+	writer   *bufio.Writer
+	strings  uint64
+	features []byte
+	leaves   []rtreeEntry
+	aliases  []byte
+	words    map[string][]uint32
+	trigrams map[string][]uint32
+	count    uint32
+}
+
+// Create starts a new index file at path.
+func Create(path string) (*Builder, error) {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %v", path, err)
+	}
+
+	b := &Builder{
+		path:     path,
+		file:     file,
+		writer:   bufio.NewWriterSize(file, 1<<20),
+		words:    make(map[string][]uint32),
+		trigrams: make(map[string][]uint32),
+	}
+
+	// The header is written when the offsets of all sections are known
+	if _, err := b.writer.Write(make([]byte, headerSize)); err != nil {
+		b.Abort()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// AddFeature adds a feature and returns its id, ids are assigned in order starting at 1.
+func (b *Builder) AddFeature(feature Feature) (uint64, error) {
+	id := uint64(len(b.features)/featureSize + 1)
+	record := binary.LittleEndian.AppendUint64(nil, id)
+	for _, s := range []string{feature.Name, feature.Class, feature.Subclass, strings.Join(feature.Divisions, ";"), string(feature.Geom)} {
+		ref, err := b.writeString(s)
+		if err != nil {
+			return 0, err
+		}
+		record = append(record, ref...)
+	}
+
+	for _, v := range []float64{feature.Bounds.MinLon, feature.Bounds.MinLat, feature.Bounds.MaxLon, feature.Bounds.MaxLat} {
+		record = binary.LittleEndian.AppendUint64(record, math.Float64bits(v))
+	}
+
+	b.features = append(b.features, record...)
+	b.leaves = append(b.leaves, rtreeEntry{bounds: feature.Bounds, ref: id})
+
+	return id, nil
+}
+
+// AddAlias adds an alias of a feature and adds it to the postings of its words and trigrams.
+func (b *Builder) AddAlias(alias Alias) error {
+	ref, err := b.writeString(alias.Alias)
+	if err != nil {
+		return err
+	}
+
+	record := binary.LittleEndian.AppendUint64(nil, alias.FeatureID)
+	record = append(record, ref...)
+	record = binary.LittleEndian.AppendUint32(record, uint32(int32(alias.ClassRank)))
+	record = binary.LittleEndian.AppendUint32(record, uint32(int32(alias.SubclassRank)))
+	record = binary.LittleEndian.AppendUint32(record, math.Float32bits(float32(alias.Importance)))
+	record = binary.LittleEndian.AppendUint32(record, uint32(alias.WordCount))
+	record = binary.LittleEndian.AppendUint32(record, uint32(alias.CharCount))
+	b.aliases = append(b.aliases, record...)
+
+	n := b.count
+	b.count++
+
+	for _, word := range text.Words(alias.Alias) {
+		b.words[word] = appendPosting(b.words[word], n)
+		for _, trigram := range text.Trigrams(word) {
+			b.trigrams[trigram] = appendPosting(b.trigrams[trigram], n)
+		}
+	}
+
+	return nil
+}
+
+// appendPosting adds an alias to a postings list once, aliases are added in ascending order.
+func appendPosting(postings []uint32, n uint32) []uint32 {
+	if len(postings) > 0 && postings[len(postings)-1] == n {
+		return postings
+	}
+
+	return append(postings, n)
+}
+
+// Finish writes the remaining sections and the header and moves the file into place.
+func (b *Builder) Finish() error {
+	var sections [sectionCount][2]uint64
+
+	// The terms of the dictionaries are written to the strings section first
+	words, err := b.dictionary(b.words)
+	if err != nil {
+		b.Abort()
+		return err
+	}
+
+	trigrams, err := b.dictionary(b.trigrams)
+	if err != nil {
+		b.Abort()
+		return err
+	}
+	sections[sectionStrings] = [2]uint64{headerSize, b.strings}
+
+	offset := headerSize + b.strings
+	for _, section := range []struct {
+		id   int
+		data []byte
+	}{
+		{sectionFeatures, b.features},
+		{sectionAliases, b.aliases},
+		{sectionWords, words.terms},
+		{sectionWordPostings, words.postings},
+		{sectionTrigrams, trigrams.terms},
+		{sectionTrigramPostings, trigrams.postings},
+		{sectionRTree, packRTree(b.leaves)},
+	} {
+		if _, err := b.writer.Write(section.data); err != nil {
+			b.Abort()
+			return err
+		}
+		sections[section.id] = [2]uint64{offset, uint64(len(section.data))}
+		offset += uint64(len(section.data))
+	}
+
+	header := append([]byte(magic), make([]byte, 8)...)
+	binary.LittleEndian.PutUint32(header[8:], version)
+	binary.LittleEndian.PutUint32(header[12:], sectionCount)
+	for _, section := range sections {
+		header = binary.LittleEndian.AppendUint64(header, section[0])
+		header = binary.LittleEndian.AppendUint64(header, section[1])
+	}
+
+	if err := b.writer.Flush(); err != nil {
+		b.Abort()
+		return err
+	}
+
+	if _, err := b.file.WriteAt(header, 0); err != nil {
+		b.Abort()
+		return err
+	}
+
+	if err := b.file.Close(); err != nil {
+		os.Remove(b.file.Name())
+		return err
+	}
+
+	return os.Rename(b.file.Name(), b.path)
+}
+
+// Abort stops building and removes the unfinished file.
+func (b *Builder) Abort() {
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
+
+type dictionary struct {
+	terms    []byte
+	postings []byte
+}
+
+// dictionary sorts the terms and writes them with references to their postings.
+func (b *Builder) dictionary(postings map[string][]uint32) (dictionary, error) {
+	terms := make([]string, 0, len(postings))
+	for term := range postings {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	var d dictionary
+	for _, term := range terms {
+		ref, err := b.writeString(term)
+		if err != nil {
+			return d, err
+		}
+
+		list := postings[term]
+		d.terms = append(d.terms, ref...)
+		d.terms = binary.LittleEndian.AppendUint64(d.terms, uint64(len(d.postings)))
+		d.terms = binary.LittleEndian.AppendUint32(d.terms, uint32(len(list)))
+		for _, n := range list {
+			d.postings = binary.LittleEndian.AppendUint32(d.postings, n)
+		}
+	}
+
+	return d, nil
+}
+
+// writeString writes a string to the strings section and returns a reference to it.
+func (b *Builder) writeString(s string) ([]byte, error) {
+	ref := binary.LittleEndian.AppendUint64(nil, b.strings)
+	ref = binary.LittleEndian.AppendUint32(ref, uint32(len(s)))
+	if _, err := b.writer.WriteString(s); err != nil {
+		return nil, err
+	}
+	b.strings += uint64(len(s))
+
+	return ref, nil
+}
@@ -0,0 +1,266 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tebben/geocodeur/geometry"
+)
+
+// An index file starts with a header containing the magic, the format version and the offset and
+// length of every section. All numbers are little endian and all records have a fixed size so they
+// can be read straight from the memory-mapped file:
+//
+//   - strings: the names, aliases, terms and GeoJSON geometries the other sections refer to
+//   - features: the features ordered by id, the id of the first feature is 1
+//   - aliases: the aliases to search the features on
+//   - words, trigrams: sorted dictionaries of the words and trigrams of the aliases, words can be
+//     looked up by prefix with a binary search
+//   - word postings, trigram postings: the ascending alias numbers of the dictionary entries
+//   - rtree: a packed R-tree on the bounds of the features
+const (
+	magic   = "GEOCIDX\x00"
+	version = 1
+)
+
+const (
+	sectionStrings = iota
+	sectionFeatures
+	sectionAliases
+	sectionWords
+	sectionWordPostings
+	sectionTrigrams
+	sectionTrigramPostings
+	sectionRTree
+	sectionCount
+)
+
+const (
+	headerSize  = 16 + sectionCount*16
+	refSize     = 12
+	featureSize = 8 + 5*refSize + 32
+	aliasSize   = 8 + refSize + 5*4
+	termSize    = refSize + 8 + 4
+)
+
+// Feature is a feature stored in an index.
+type Feature struct {
+	ID        uint64
+	Name      string
+	Class     string
+	Subclass  string
+	Divisions []string
+	Geom      json.RawMessage
+	Bounds    geometry.Bounds
+}
+
+// Alias is an alias to search a feature on, it has the columns of the search table.
+type Alias struct {
+	FeatureID    uint64
+	Alias        string
+	ClassRank    int
+	SubclassRank int
+	Importance   float64
+	WordCount    int
+	CharCount    int
+}
+
+// Index is an index file opened with Open, the file is memory-mapped and records are only
+// read when they are used. Everything returned by the index is copied so it can be used
+// after the index is closed.
+type Index struct {
+	data     []byte
+	sections [sectionCount][]byte
+}
+
+// Open memory-maps an index file created with a Builder.
+func Open(path string) (*Index, error) {
+	data, err := mmapFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+
+	index := &Index{data: data}
+	if err := index.readHeader(); err != nil {
+		munmap(data)
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+
+	return index, nil
+}
+
+func (ix *Index) readHeader() error {
+	if len(ix.data) < headerSize || string(ix.data[:8]) != magic {
+		return fmt.Errorf("not an index file")
+	}
+
+	if v := binary.LittleEndian.Uint32(ix.data[8:]); v != version {
+		return fmt.Errorf("index version %d is not supported, rebuild the index", v)
+	}
+
+	for i := range ix.sections {
+		offset := binary.LittleEndian.Uint64(ix.data[16+i*16:])
+		length := binary.LittleEndian.Uint64(ix.data[24+i*16:])
+		if offset+length > uint64(len(ix.data)) {
+			return fmt.Errorf("index file is truncated")
+		}
+		ix.sections[i] = ix.data[offset : offset+length]
+	}
+
+	return nil
+}
+
+// Close unmaps the index file.
+func (ix *Index) Close() error {
+	return munmap(ix.data)
+}
+
+// FeatureCount returns the number of features, the ids of the features are 1 up to and including the count.
+func (ix *Index) FeatureCount() int {
+	return len(ix.sections[sectionFeatures]) / featureSize
+}
+
+// Feature returns the feature with the given id, the geometry is only read when includeGeometry is set.
+func (ix *Index) Feature(id uint64, includeGeometry bool) (Feature, bool) {
+	if id == 0 || id > uint64(ix.FeatureCount()) {
+		return Feature{}, false
+	}
+
+	record := ix.sections[sectionFeatures][(id-1)*featureSize:]
+	feature := Feature{
+		ID:       binary.LittleEndian.Uint64(record),
+		Name:     ix.str(record[8:]),
+		Class:    ix.str(record[8+refSize:]),
+		Subclass: ix.str(record[8+2*refSize:]),
+		Bounds: geometry.Bounds{
+			MinLon: float(record[8+5*refSize:]),
+			MinLat: float(record[16+5*refSize:]),
+			MaxLon: float(record[24+5*refSize:]),
+			MaxLat: float(record[32+5*refSize:]),
+		},
+	}
+
+	feature.Divisions = []string{}
+	if divisions := ix.str(record[8+3*refSize:]); divisions != "" {
+		feature.Divisions = strings.Split(divisions, ";")
+	}
+
+	if includeGeometry {
+		feature.Geom = bytes.Clone(ix.bytes(record[8+4*refSize:]))
+	}
+
+	return feature, true
+}
+
+// FeatureClass returns the class of the feature with the given id without reading the rest of the feature.
+func (ix *Index) FeatureClass(id uint64) string {
+	if id == 0 || id > uint64(ix.FeatureCount()) {
+		return ""
+	}
+
+	return ix.str(ix.sections[sectionFeatures][(id-1)*featureSize+8+refSize:])
+}
+
+// AliasCount returns the number of aliases, aliases are numbered from 0.
+func (ix *Index) AliasCount() int {
+	return len(ix.sections[sectionAliases]) / aliasSize
+}
+
+// Alias returns the alias with the given number.
+func (ix *Index) Alias(n uint32) Alias {
+	record := ix.sections[sectionAliases][int(n)*aliasSize:]
+	return Alias{
+		FeatureID:    binary.LittleEndian.Uint64(record),
+		Alias:        ix.str(record[8:]),
+		ClassRank:    int(int32(binary.LittleEndian.Uint32(record[8+refSize:]))),
+		SubclassRank: int(int32(binary.LittleEndian.Uint32(record[12+refSize:]))),
+		Importance:   float64(math.Float32frombits(binary.LittleEndian.Uint32(record[16+refSize:]))),
+		WordCount:    int(binary.LittleEndian.Uint32(record[20+refSize:])),
+		CharCount:    int(binary.LittleEndian.Uint32(record[24+refSize:])),
+	}
+}
+
+// WordPostings returns the numbers of the aliases containing the word.
+func (ix *Index) WordPostings(word string) []uint32 {
+	return ix.postings(sectionWords, sectionWordPostings, word, false)
+}
+
+// PrefixPostings returns the numbers of the aliases containing a word starting with the prefix.
+func (ix *Index) PrefixPostings(prefix string) []uint32 {
+	return ix.postings(sectionWords, sectionWordPostings, prefix, true)
+}
+
+// TrigramPostings returns the numbers of the aliases containing the trigram.
+func (ix *Index) TrigramPostings(trigram string) []uint32 {
+	return ix.postings(sectionTrigrams, sectionTrigramPostings, trigram, false)
+}
+
+// Words calls fn for every word in the aliases with the number of aliases it occurs in.
+func (ix *Index) Words(fn func(word string, count int)) {
+	terms := ix.sections[sectionWords]
+	for i := 0; i < len(terms)/termSize; i++ {
+		entry := terms[i*termSize:]
+		fn(ix.str(entry), int(binary.LittleEndian.Uint32(entry[refSize+8:])))
+	}
+}
+
+// postings looks up a term in a dictionary and returns the merged postings of the
+// term or of all terms starting with it when prefix is set.
+func (ix *Index) postings(dictionary int, postings int, term string, prefix bool) []uint32 {
+	terms := ix.sections[dictionary]
+	count := len(terms) / termSize
+	first := sort.Search(count, func(i int) bool {
+		return string(ix.bytes(terms[i*termSize:])) >= term
+	})
+
+	var result []uint32
+	merged := 0
+	for i := first; i < count; i++ {
+		entry := terms[i*termSize:]
+		word := ix.bytes(entry)
+		if prefix && !bytes.HasPrefix(word, []byte(term)) || !prefix && string(word) != term {
+			break
+		}
+
+		offset := binary.LittleEndian.Uint64(entry[refSize:])
+		n := int(binary.LittleEndian.Uint32(entry[refSize+8:]))
+		list := ix.sections[postings][offset : offset+uint64(n)*4]
+		for j := 0; j < n; j++ {
+			result = append(result, binary.LittleEndian.Uint32(list[j*4:]))
+		}
+		merged++
+	}
+
+	if merged > 1 {
+		slices.Sort(result)
+		result = slices.Compact(result)
+	}
+
+	return result
+}
+
+// Intersects returns the ids of the features with bounds intersecting the given bounds.
+func (ix *Index) Intersects(bounds geometry.Bounds) []uint64 {
+	return ix.rtree().search(bounds)
+}
+
+// bytes returns the bytes of the string a reference at the start of b points to.
+func (ix *Index) bytes(b []byte) []byte {
+	offset := binary.LittleEndian.Uint64(b)
+	length := uint64(binary.LittleEndian.Uint32(b[8:]))
+	return ix.sections[sectionStrings][offset : offset+length]
+}
+
+func (ix *Index) str(b []byte) string {
+	return string(ix.bytes(b))
+}
+
+func float(b []byte) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
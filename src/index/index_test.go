@@ -0,0 +1,271 @@
+package index
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tebben/geocodeur/geometry"
+)
+
+var (
+	vught     = geometry.Bounds{MinLon: 5.29, MinLat: 51.65, MaxLon: 5.30, MaxLat: 51.66}
+	amsterdam = geometry.Bounds{MinLon: 4.88, MinLat: 52.36, MaxLon: 4.89, MaxLat: 52.37}
+	zwolle    = geometry.Bounds{MinLon: 6.08, MinLat: 52.50, MaxLon: 6.10, MaxLat: 52.52}
+)
+
+// testFeatures are the features of the test index with their aliases, the aliases are numbered
+// in this order starting at 0.
+var testFeatures = []struct {
+	feature Feature
+	aliases []string
+}{
+	{Feature{Name: "Kerkstraat", Class: "road", Subclass: "residential", Divisions: []string{"Vught"}, Geom: json.RawMessage(`{"type":"Point","coordinates":[5.295,51.655]}`), Bounds: vught}, []string{"Kerkstraat Vught", "Kerkstraat"}},
+	{Feature{Name: "Kerkstraat", Class: "road", Subclass: "residential", Divisions: []string{"Amsterdam", "Noord-Holland"}, Geom: json.RawMessage(`{"type":"Point","coordinates":[4.885,52.365]}`), Bounds: amsterdam}, []string{"Kerkstraat Amsterdam", "Kerkstraat"}},
+	{Feature{Name: "Vught", Class: "division", Subclass: "locality", Divisions: []string{}, Geom: json.RawMessage(`{"type":"Point","coordinates":[5.295,51.655]}`), Bounds: vught}, []string{"Vught"}},
+	{Feature{Name: "Amsterdam", Class: "division", Subclass: "locality", Divisions: []string{}, Geom: json.RawMessage(`{"type":"Point","coordinates":[4.885,52.365]}`), Bounds: amsterdam}, []string{"Amsterdam", "Aa"}},
+	{Feature{Name: "Zwolle", Class: "division", Subclass: "locality", Divisions: []string{}, Geom: json.RawMessage(`{"type":"Point","coordinates":[6.09,52.51]}`), Bounds: zwolle}, []string{"Zwolle"}},
+}
+
+func buildTestIndex(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.idx")
+	b, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tf := range testFeatures {
+		id, err := b.AddFeature(tf.feature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != uint64(i+1) {
+			t.Fatalf("feature %d got id %d", i+1, id)
+		}
+
+		for _, alias := range tf.aliases {
+			err := b.AddAlias(Alias{
+				FeatureID:    id,
+				Alias:        alias,
+				ClassRank:    i,
+				SubclassRank: -i,
+				Importance:   0.5,
+				WordCount:    len(strings.Fields(alias)),
+				CharCount:    len([]rune(alias)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file is left after finishing: %v", err)
+	}
+
+	return path
+}
+
+func openTestIndex(t *testing.T) *Index {
+	t.Helper()
+
+	ix, err := Open(buildTestIndex(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ix.Close() })
+
+	return ix
+}
+
+func TestRoundTrip(t *testing.T) {
+	ix := openTestIndex(t)
+
+	if got := ix.FeatureCount(); got != len(testFeatures) {
+		t.Fatalf("FeatureCount() = %d, want %d", got, len(testFeatures))
+	}
+
+	n := uint32(0)
+	for i, tf := range testFeatures {
+		id := uint64(i + 1)
+		want := tf.feature
+		want.ID = id
+
+		got, ok := ix.Feature(id, true)
+		if !ok {
+			t.Fatalf("feature %d not found", id)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Feature(%d) = %+v, want %+v", id, got, want)
+		}
+
+		got, _ = ix.Feature(id, false)
+		if got.Geom != nil {
+			t.Errorf("Feature(%d) without geometry has geometry %s", id, got.Geom)
+		}
+
+		if class := ix.FeatureClass(id); class != want.Class {
+			t.Errorf("FeatureClass(%d) = %q, want %q", id, class, want.Class)
+		}
+
+		for _, alias := range tf.aliases {
+			want := Alias{
+				FeatureID:    id,
+				Alias:        alias,
+				ClassRank:    i,
+				SubclassRank: -i,
+				Importance:   0.5,
+				WordCount:    len(strings.Fields(alias)),
+				CharCount:    len([]rune(alias)),
+			}
+			if got := ix.Alias(n); got != want {
+				t.Errorf("Alias(%d) = %+v, want %+v", n, got, want)
+			}
+			n++
+		}
+	}
+
+	if got := ix.AliasCount(); got != int(n) {
+		t.Errorf("AliasCount() = %d, want %d", got, n)
+	}
+
+	for _, id := range []uint64{0, uint64(len(testFeatures) + 1)} {
+		if _, ok := ix.Feature(id, false); ok {
+			t.Errorf("Feature(%d) is found", id)
+		}
+		if class := ix.FeatureClass(id); class != "" {
+			t.Errorf("FeatureClass(%d) = %q, want none", id, class)
+		}
+	}
+
+	got := ix.Intersects(geometry.Bounds{MinLon: 5, MinLat: 51, MaxLon: 6, MaxLat: 52})
+	slices.Sort(got)
+	if want := []uint64{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Intersects() = %v, want %v", got, want)
+	}
+}
+
+func TestPostingsAscending(t *testing.T) {
+	ix := openTestIndex(t)
+
+	ascending := func(kind, term string, postings []uint32, count int) {
+		if len(postings) == 0 || len(postings) != count {
+			t.Errorf("%s %q has %d postings, want %d", kind, term, len(postings), count)
+		}
+		for i := 1; i < len(postings); i++ {
+			if postings[i] <= postings[i-1] {
+				t.Errorf("%s %q postings %v are not ascending", kind, term, postings)
+				break
+			}
+		}
+	}
+
+	var words []string
+	ix.Words(func(word string, count int) {
+		words = append(words, word)
+		ascending("word", word, ix.WordPostings(word), count)
+	})
+
+	if !slices.IsSorted(words) {
+		t.Errorf("words %v are not sorted", words)
+	}
+
+	trigrams := ix.sections[sectionTrigrams]
+	for i := 0; i < len(trigrams)/termSize; i++ {
+		entry := trigrams[i*termSize:]
+		trigram := ix.str(entry)
+		ascending("trigram", trigram, ix.TrigramPostings(trigram), int(binary.LittleEndian.Uint32(entry[refSize+8:])))
+	}
+
+	// Aliases 0 to 3 are the aliases of the two kerkstraat features
+	if got, want := ix.WordPostings("kerkstraat"), []uint32{0, 1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WordPostings(kerkstraat) = %v, want %v", got, want)
+	}
+}
+
+func TestPrefixPostings(t *testing.T) {
+	ix := openTestIndex(t)
+
+	// The dictionary is aa, amsterdam, kerkstraat, vught, zwolle
+	tests := []struct {
+		prefix string
+		want   []uint32
+	}{
+		{"", []uint32{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"0", nil},
+		{"a", []uint32{2, 5, 6}},
+		{"aa", []uint32{6}},
+		{"aaa", nil},
+		{"am", []uint32{2, 5}},
+		{"vu", []uint32{0, 4}},
+		{"zwolle", []uint32{7}},
+		{"zwollea", nil},
+		{"zz", nil},
+	}
+
+	for _, tt := range tests {
+		if got := ix.PrefixPostings(tt.prefix); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("PrefixPostings(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+
+	for _, word := range []string{"a", "zz", "kerk"} {
+		if got := ix.WordPostings(word); got != nil {
+			t.Errorf("WordPostings(%q) = %v, want none", word, got)
+		}
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	data, err := os.ReadFile(buildTestIndex(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongVersion := slices.Clone(data)
+	binary.LittleEndian.PutUint32(wrongVersion[8:], version+1)
+
+	wrongMagic := slices.Clone(data)
+	copy(wrongMagic, "NOTANIDX")
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"truncated", data[:len(data)-1], "index file is truncated"},
+		{"header only", data[:headerSize], "index file is truncated"},
+		{"short header", data[:headerSize-1], "not an index file"},
+		{"wrong magic", wrongMagic, "not an index file"},
+		{"wrong version", wrongVersion, "index version 2 is not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.idx")
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			ix, err := Open(path)
+			if err == nil {
+				ix.Close()
+				t.Fatalf("Open succeeded, want %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Open() error = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
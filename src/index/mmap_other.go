@@ -0,0 +1,14 @@
+//go:build !unix
+
+package index
+
+import "os"
+
+// mmapFile reads the whole file on platforms without mmap.
+func mmapFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,166 @@
+package index
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/tebben/geocodeur/geometry"
+)
+
+// rtreeNodeSize is the maximum number of entries in a node of the R-tree.
+const rtreeNodeSize = 16
+
+const rtreeEntrySize = 32 + 8 + 4
+
+// rtreeEntry is an entry of the packed R-tree. Entries of the leaf level refer to a feature id,
+// entries of the levels above refer to count consecutive entries of the level below.
+type rtreeEntry struct {
+	bounds geometry.Bounds
+	ref    uint64
+	count  uint32
+}
+
+// packRTree builds an R-tree with the Sort-Tile-Recursive algorithm. The section starts with the
+// number of levels and the first entry and number of entries of every level from the leaves up,
+// followed by the entries.
+func packRTree(leaves []rtreeEntry) []byte {
+	var levels [][]rtreeEntry
+	level := leaves
+	start := 0
+	for len(level) > 0 {
+		sortTiles(level)
+		levels = append(levels, level)
+		if len(level) <= rtreeNodeSize {
+			break
+		}
+
+		var parents []rtreeEntry
+		for i := 0; i < len(level); i += rtreeNodeSize {
+			children := level[i:min(i+rtreeNodeSize, len(level))]
+			parent := rtreeEntry{bounds: children[0].bounds, ref: uint64(start + i), count: uint32(len(children))}
+			for _, child := range children[1:] {
+				parent.bounds = union(parent.bounds, child.bounds)
+			}
+			parents = append(parents, parent)
+		}
+
+		start += len(level)
+		level = parents
+	}
+
+	section := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+	offset := 0
+	for _, level := range levels {
+		section = binary.LittleEndian.AppendUint32(section, uint32(offset))
+		section = binary.LittleEndian.AppendUint32(section, uint32(len(level)))
+		offset += len(level)
+	}
+
+	for _, level := range levels {
+		for _, entry := range level {
+			for _, v := range []float64{entry.bounds.MinLon, entry.bounds.MinLat, entry.bounds.MaxLon, entry.bounds.MaxLat} {
+				section = binary.LittleEndian.AppendUint64(section, math.Float64bits(v))
+			}
+			section = binary.LittleEndian.AppendUint64(section, entry.ref)
+			section = binary.LittleEndian.AppendUint32(section, entry.count)
+		}
+	}
+
+	return section
+}
+
+// sortTiles orders the entries in vertical slices by longitude and every slice by latitude,
+// so consecutive entries, which end up in the same node, are close to each other.
+func sortTiles(entries []rtreeEntry) {
+	nodes := (len(entries) + rtreeNodeSize - 1) / rtreeNodeSize
+	sliceSize := int(math.Ceil(math.Sqrt(float64(nodes)))) * rtreeNodeSize
+
+	sort.Slice(entries, func(i, j int) bool {
+		return center(entries[i].bounds.MinLon, entries[i].bounds.MaxLon) < center(entries[j].bounds.MinLon, entries[j].bounds.MaxLon)
+	})
+
+	for i := 0; i < len(entries); i += sliceSize {
+		slice := entries[i:min(i+sliceSize, len(entries))]
+		sort.Slice(slice, func(i, j int) bool {
+			return center(slice[i].bounds.MinLat, slice[i].bounds.MaxLat) < center(slice[j].bounds.MinLat, slice[j].bounds.MaxLat)
+		})
+	}
+}
+
+type rtree struct {
+	section []byte
+	levels  int
+}
+
+func (ix *Index) rtree() rtree {
+	section := ix.sections[sectionRTree]
+	if len(section) < 4 {
+		return rtree{}
+	}
+
+	return rtree{section: section, levels: int(binary.LittleEndian.Uint32(section))}
+}
+
+func (t rtree) level(i int) (int, int) {
+	return int(binary.LittleEndian.Uint32(t.section[4+i*8:])), int(binary.LittleEndian.Uint32(t.section[8+i*8:]))
+}
+
+func (t rtree) entry(i int) rtreeEntry {
+	record := t.section[4+t.levels*8+i*rtreeEntrySize:]
+	return rtreeEntry{
+		bounds: geometry.Bounds{
+			MinLon: float(record),
+			MinLat: float(record[8:]),
+			MaxLon: float(record[16:]),
+			MaxLat: float(record[24:]),
+		},
+		ref:   binary.LittleEndian.Uint64(record[32:]),
+		count: binary.LittleEndian.Uint32(record[40:]),
+	}
+}
+
+func (t rtree) search(bounds geometry.Bounds) []uint64 {
+	if t.levels == 0 {
+		return nil
+	}
+
+	var ids []uint64
+	var visit func(level int, first int, count int)
+	visit = func(level int, first int, count int) {
+		for i := first; i < first+count; i++ {
+			entry := t.entry(i)
+			if !intersects(entry.bounds, bounds) {
+				continue
+			}
+
+			if level == 0 {
+				ids = append(ids, entry.ref)
+			} else {
+				visit(level-1, int(entry.ref), int(entry.count))
+			}
+		}
+	}
+
+	first, count := t.level(t.levels - 1)
+	visit(t.levels-1, first, count)
+
+	return ids
+}
+
+func union(a geometry.Bounds, b geometry.Bounds) geometry.Bounds {
+	return geometry.Bounds{
+		MinLon: math.Min(a.MinLon, b.MinLon),
+		MinLat: math.Min(a.MinLat, b.MinLat),
+		MaxLon: math.Max(a.MaxLon, b.MaxLon),
+		MaxLat: math.Max(a.MaxLat, b.MaxLat),
+	}
+}
+
+func intersects(a geometry.Bounds, b geometry.Bounds) bool {
+	return a.MinLon <= b.MaxLon && b.MinLon <= a.MaxLon && a.MinLat <= b.MaxLat && b.MinLat <= a.MaxLat
+}
+
+func center(min float64, max float64) float64 {
+	return (min + max) / 2
+}
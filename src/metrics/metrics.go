@@ -0,0 +1,127 @@
+// Package metrics exposes the Prometheus metrics of the API, the database pools and the geocoder.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tebben/geocodeur/database"
+)
+
+const namespace = "geocodeur"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	throttled = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_throttled_requests_total",
+		Help:      "Number of requests rejected because the maximum number of concurrent requests was reached.",
+	})
+
+	geocodeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "geocode_duration_seconds",
+		Help:      "Duration of geocode searches by dataset, search type: fts, fuzzy, trgm or none when nothing was found, and response status.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"dataset", "search_type", "status"})
+
+	geocodeResults = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "geocode_results",
+		Help:      "Number of results per geocode search by dataset and response status.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	}, []string{"dataset", "status"})
+
+	geocodeEmpty = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "geocode_empty_results_total",
+		Help:      "Number of geocode searches without results by dataset.",
+	}, []string{"dataset"})
+)
+
+func init() {
+	prometheus.MustRegister(poolCollector{})
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRequest records a handled HTTP request, route is the matched route pattern.
+func ObserveRequest(route string, method string, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(route, method, code).Inc()
+	httpDuration.WithLabelValues(route, method, code).Observe(duration.Seconds())
+}
+
+// ObserveThrottled records a request that was rejected by the throttle.
+func ObserveThrottled() {
+	throttled.Inc()
+}
+
+// ObserveGeocode records a geocode search of a dataset with the search type of the results and the status
+// it responded with, failed searches like timeouts have no results. Only successful searches without
+// results are counted as empty.
+func ObserveGeocode(dataset string, searchType string, results int, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	if results == 0 {
+		searchType = "none"
+		if status == http.StatusOK {
+			geocodeEmpty.WithLabelValues(dataset).Inc()
+		}
+	}
+
+	geocodeDuration.WithLabelValues(dataset, searchType, code).Observe(duration.Seconds())
+	geocodeResults.WithLabelValues(dataset, code).Observe(float64(results))
+}
+
+var (
+	poolLabels       = []string{"pool"}
+	poolAcquired     = prometheus.NewDesc(namespace+"_db_pool_acquired_connections", "Number of connections in use.", poolLabels, nil)
+	poolIdle         = prometheus.NewDesc(namespace+"_db_pool_idle_connections", "Number of idle connections.", poolLabels, nil)
+	poolTotal        = prometheus.NewDesc(namespace+"_db_pool_total_connections", "Number of open connections.", poolLabels, nil)
+	poolMax          = prometheus.NewDesc(namespace+"_db_pool_max_connections", "Maximum number of connections.", poolLabels, nil)
+	poolAcquires     = prometheus.NewDesc(namespace+"_db_pool_acquires_total", "Number of connections acquired from the pool.", poolLabels, nil)
+	poolEmptyWaits   = prometheus.NewDesc(namespace+"_db_pool_empty_acquires_total", "Number of acquires that waited for a connection because the pool was empty.", poolLabels, nil)
+	poolCanceled     = prometheus.NewDesc(namespace+"_db_pool_canceled_acquires_total", "Number of acquires canceled by their context.", poolLabels, nil)
+	poolAcquireWaits = prometheus.NewDesc(namespace+"_db_pool_acquire_duration_seconds_total", "Total time spent acquiring connections.", poolLabels, nil)
+)
+
+// poolCollector reports the statistics of the database pools when metrics are scraped.
+type poolCollector struct{}
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{poolAcquired, poolIdle, poolTotal, poolMax, poolAcquires, poolEmptyWaits, poolCanceled, poolAcquireWaits} {
+		ch <- desc
+	}
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, stat := range database.PoolStats() {
+		ch <- prometheus.MustNewConstMetric(poolAcquired, prometheus.GaugeValue, float64(stat.AcquiredConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolIdle, prometheus.GaugeValue, float64(stat.IdleConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, float64(stat.TotalConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolMax, prometheus.GaugeValue, float64(stat.MaxConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(poolEmptyWaits, prometheus.CounterValue, float64(stat.EmptyAcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(poolCanceled, prometheus.CounterValue, float64(stat.CanceledAcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(poolAcquireWaits, prometheus.CounterValue, stat.AcquireDuration().Seconds(), name)
+	}
+}
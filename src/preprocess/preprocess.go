@@ -10,22 +10,22 @@ import (
 	"github.com/tebben/geocodeur/settings"
 )
 
-func ProcessAll() {
-	process("division", queries.DivisionQuery)
-	process("road", queries.RoadQuery)
-	process("water", queries.WaterQuery)
-	process("poi", queries.PoiQuery)
-	process("infra", queries.InfraQuery)
-	process("address", queries.AddressQuery)
-	process("zipcode", queries.ZipcodeQuery)
+func ProcessAll(config settings.ProcessConfig) {
+	process(config, "division", queries.DivisionQuery)
+	process(config, "road", queries.RoadQuery)
+	process(config, "water", queries.WaterQuery)
+	process(config, "poi", queries.PoiQuery)
+	process(config, "infra", queries.InfraQuery)
+	process(config, "address", queries.AddressQuery)
+	process(config, "zipcode", queries.ZipcodeQuery)
 }
 
-func process(name string, query string) {
+func process(config settings.ProcessConfig, name string, query string) {
 	log.Infof("Processing data: %s", name)
 
-	config := settings.GetConfig()
-	query = strings.ReplaceAll(query, "%DATADIR%", config.Process.Folder)
-	query = strings.ReplaceAll(query, "%COUNTRY%", strings.ToLower(config.Process.CountryClip))
+	query = queries.ImportanceMacros + query
+	query = strings.ReplaceAll(query, "%DATADIR%", config.Folder)
+	query = strings.ReplaceAll(query, "%COUNTRY%", strings.ToLower(config.CountryClip))
 
 	db, err := getDuckDB()
 	if err != nil {
@@ -40,7 +40,8 @@ COPY (
 		ST_AsText(a.geometry) AS geom,
 		'address' as class,
 		'address' as subclass,
-		array_to_string([x.value for x in address_levels], ';') as relation
+		array_to_string([x.value for x in address_levels], ';') as relation,
+		0.0::DOUBLE as importance
 	FROM
 		read_parquet('%DATADIR%address.geoparquet') AS a, clip AS b
 	WHERE
@@ -18,8 +18,15 @@ var DivisionQuery = `
             a.names.primary AS name,
             a.geometry AS geom,
             'division' AS class,
-            a.subtype AS subclass
-        FROM read_parquet('%DATADIR%division_area.geoparquet') AS a, clip AS b
+            a.subtype AS subclass,
+            ROUND(
+                0.5 * log_scale(p.population, 10000000) +
+                0.3 * log_scale(area_km2(a.geometry), 10000) +
+                0.2 * has_wikidata(p.wikidata)
+            , 4)::DOUBLE AS importance
+        FROM read_parquet('%DATADIR%division_area.geoparquet') AS a
+        LEFT JOIN read_parquet('%DATADIR%division.geoparquet') AS p
+        ON a.division_id = p.id, clip AS b
         WHERE
             ST_Intersects(a.geometry, b.geom)
     ),
@@ -43,11 +50,12 @@ var DivisionQuery = `
             ST_AsText(d.geom) AS geom,
             d.class,
             d.subclass,
+            d.importance,
             STRING_AGG(DISTINCT r.relation_name, ';') FILTER (WHERE r.relation_name IS NOT NULL) AS relation
         FROM divisions d
         LEFT JOIN relations r
         ON d.id = r.id
-        GROUP BY d.id, d.name, d.geom, d.class, d.subclass
+        GROUP BY d.id, d.name, d.geom, d.class, d.subclass, d.importance
     )
     SELECT
         id,
@@ -55,7 +63,8 @@ var DivisionQuery = `
         geom,
         class,
         subclass,
-        relation
+        relation,
+        importance
     FROM aggregated_relations
 ) TO '%DATADIR%geocodeur_division.parquet' (FORMAT 'PARQUET');
 `
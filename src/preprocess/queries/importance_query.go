@@ -0,0 +1,25 @@
+package queries
+
+// ImportanceMacros are run before every preprocess query and contain the
+// helpers to calculate the importance of a feature, the importance is a score
+// between 0 and 1 used as tie-breaker when ranking features with the same name.
+var ImportanceMacros = `
+INSTALL spatial;
+LOAD spatial;
+
+-- Approximate length in kilometers of a geometry in EPSG:4326
+CREATE OR REPLACE MACRO length_km(g) AS
+    ST_Length(g) * 111.32 * cos(radians(ST_Y(ST_Centroid(g))));
+
+-- Approximate area in square kilometers of a geometry in EPSG:4326
+CREATE OR REPLACE MACRO area_km2(g) AS
+    ST_Area(g) * 111.32 * 111.32 * cos(radians(ST_Y(ST_Centroid(g))));
+
+-- Scale a value logarithmically between 0 and 1, values of max and higher score 1
+CREATE OR REPLACE MACRO log_scale(v, max) AS
+    LEAST(1.0, LN(1 + GREATEST(COALESCE(v, 0), 0)) / LN(1 + max));
+
+-- 1 when a wikidata id is present, otherwise 0
+CREATE OR REPLACE MACRO has_wikidata(w) AS
+    CASE WHEN w IS NOT NULL AND w != '' THEN 1.0 ELSE 0.0 END;
+`
@@ -206,7 +206,8 @@ CREATE OR REPLACE TABLE clipped_features AS (
 		a.names.primary AS name,
 		'infra' AS class,
 		a.class AS subclass,
-		a.geometry AS geom
+		a.geometry AS geom,
+		a.wikidata
 	FROM
 		read_parquet('%DATADIR%infrastructure.geoparquet') AS a, clip AS b
 	WHERE
@@ -255,6 +256,7 @@ COPY (
             a.class,
             a.subclass,
             a.geom,
+            a.wikidata,
             b.id as group_id
         FROM
             clipped_features AS a
@@ -269,11 +271,14 @@ COPY (
     ),
     merged AS (
         SELECT
-            REPLACE(UUID()::string, '-', '') AS id,
+            -- The lowest Overture id of the merged features, this keeps the id
+            -- stable between runs so features can be updated incrementally
+            MIN(a.id) AS id,
             a.name,
             a.class,
             a.subclass,
-           	ST_Collect(ARRAY_AGG(a.geom)) AS geom
+           	ST_Collect(ARRAY_AGG(a.geom)) AS geom,
+            ANY_VALUE(a.wikidata) AS wikidata
         FROM
             features AS a
         GROUP BY
@@ -299,6 +304,11 @@ COPY (
             ST_AsText(a.geom) AS geom,
             a.class,
             a.subclass,
+            ROUND(
+                0.4 * log_scale(area_km2(a.geom), 10) +
+                0.2 * log_scale(length_km(a.geom), 10) +
+                0.4 * has_wikidata(a.wikidata)
+            , 4)::DOUBLE AS importance,
             STRING_AGG(DISTINCT b.relation_name, ';') FILTER (WHERE b.relation_name IS NOT NULL) AS relation
         FROM
             merged AS a
@@ -307,7 +317,7 @@ COPY (
         ON
             a.id = b.id
         GROUP BY
-            a.id, a.name, a.geom, a.class, a.subclass
+            a.id, a.name, a.geom, a.class, a.subclass, a.wikidata
     )
     SELECT
         id,
@@ -315,7 +325,8 @@ COPY (
         geom,
         class,
         subclass,
-        relation
+        relation,
+        importance
     FROM aggregated_relations
 ) TO '%DATADIR%geocodeur_infra.parquet' (FORMAT 'PARQUET');
 `
@@ -18,7 +18,8 @@ COPY (
             a.names.primary AS name,
             a.geometry AS geom,
             'poi' AS class,
-            NULL AS subclass
+            NULL AS subclass,
+            ROUND(0.8 * a.confidence + 0.2 * has_wikidata(a.brand.wikidata), 4)::DOUBLE AS importance
         FROM read_parquet('%DATADIR%place.geoparquet') AS a, clip AS b
         WHERE
             ST_Intersects(a.geometry, b.geom)
@@ -42,11 +43,12 @@ COPY (
             ST_AsText(d.geom) AS geom,
             d.class,
             d.subclass,
+            d.importance,
             STRING_AGG(DISTINCT r.relation_name, ';') FILTER (WHERE r.relation_name IS NOT NULL) AS relation
         FROM pois d
         LEFT JOIN relations r
         ON d.id = r.id
-        GROUP BY d.id, d.name, d.geom, d.class, d.subclass
+        GROUP BY d.id, d.name, d.geom, d.class, d.subclass, d.importance
     )
     SELECT
         id,
@@ -54,7 +56,8 @@ COPY (
         geom,
         class,
         subclass,
-        relation
+        relation,
+        importance
     FROM aggregated_relations
 ) TO '%DATADIR%geocodeur_poi.parquet' (FORMAT 'PARQUET');
 `
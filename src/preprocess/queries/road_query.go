@@ -97,7 +97,9 @@ COPY (
     ),
     merged AS (
         SELECT
-            REPLACE(UUID()::string, '-', '') AS id,
+            -- The lowest Overture id of the merged features, this keeps the id
+            -- stable between runs so features can be updated incrementally
+            MIN(a.id) AS id,
             a.name,
             a.class,
             a.subclass,
@@ -127,6 +129,7 @@ COPY (
             ST_AsText(a.geom) AS geom,
             a.class,
             a.subclass,
+            ROUND(log_scale(length_km(a.geom), 100), 4)::DOUBLE AS importance,
             STRING_AGG(DISTINCT b.relation_name, ';') FILTER (WHERE b.relation_name IS NOT NULL) AS relation
         FROM
             merged AS a
@@ -143,7 +146,8 @@ COPY (
         geom,
         class,
         subclass,
-        relation
+        relation,
+        importance
     FROM aggregated_relations
 ) TO '%DATADIR%geocodeur_segment.parquet' (FORMAT 'PARQUET');
 `
@@ -127,7 +127,8 @@ COPY (
             a.names.primary as name,
             'water' AS class,
             a.class AS subclass,
-            a.geometry AS geom
+            a.geometry AS geom,
+            a.wikidata
         FROM
             read_parquet('%DATADIR%water.geoparquet') AS a, clip AS b
         WHERE
@@ -142,6 +143,7 @@ COPY (
             a.class,
             a.subclass,
             a.geom,
+            a.wikidata,
             b.id as group_id
         FROM
             clipped_features AS a
@@ -156,11 +158,14 @@ COPY (
     ),
     merged_features AS (
         SELECT
-            REPLACE(UUID()::string, '-', '') AS id,
+            -- The lowest Overture id of the merged features, this keeps the id
+            -- stable between runs so features can be updated incrementally
+            MIN(a.id) AS id,
             a.name,
             a.class,
             a.subclass,
-            ST_Collect(ARRAY_AGG(a.geom)) AS geom
+            ST_Collect(ARRAY_AGG(a.geom)) AS geom,
+            ANY_VALUE(a.wikidata) AS wikidata
         FROM
             features AS a
         GROUP BY
@@ -184,11 +189,15 @@ COPY (
             ST_AsText(a.geom) AS geom,
             a.class,
             a.subclass,
+            ROUND(
+                0.8 * GREATEST(log_scale(area_km2(a.geom), 1000), log_scale(length_km(a.geom), 100)) +
+                0.2 * has_wikidata(a.wikidata)
+            , 4)::DOUBLE AS importance,
             STRING_AGG(DISTINCT b.relation_name, ';') FILTER (WHERE b.relation_name IS NOT NULL) AS relation
         FROM merged_features a
         LEFT JOIN relations b
         ON a.id = b.id
-        GROUP BY a.id, a.name, a.geom, a.class, a.subclass
+        GROUP BY a.id, a.name, a.geom, a.class, a.subclass, a.wikidata
     )
     SELECT
         id,
@@ -196,7 +205,8 @@ COPY (
         geom,
         class,
         subclass,
-        relation
+        relation,
+        importance
     FROM aggregated_relations
 ) TO '%DATADIR%geocodeur_water.parquet' (FORMAT 'PARQUET');
 `
@@ -14,7 +14,7 @@ COPY (
     ),
 	zips AS (
 		SELECT
-			ANY_VALUE(id) as id,
+			'zipcode-' || postcode as id,
 			postcode as zipcode,
 			ST_ConvexHull(ST_Union_Agg(geometry)) AS geom
 		FROM
@@ -28,7 +28,8 @@ COPY (
 		ST_AsText(a.geom) AS geom,
 		'zipcode' as class,
 		'zipcode' as subclass,
-		NULL::VARCHAR as relation
+		NULL::VARCHAR as relation,
+		0.0::DOUBLE as importance
 	FROM
 		zips AS a, clip AS b
 	WHERE
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tebben/geocodeur/service"
+	"github.com/tebben/geocodeur/settings"
+)
+
+// swappableHandler serves requests with the latest router, requests in flight finish on the router they started on.
+type swappableHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func (h *swappableHandler) set(handler http.Handler) {
+	h.handler.Store(&handler)
+}
+
+func (h *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.handler.Load()).ServeHTTP(w, r)
+}
+
+// reload loads the configuration again on SIGHUP and swaps in a router with the new CORS, timeout, similarity
+// threshold, statement timeout and ranking weights, the synonyms are reloaded as well. The vocabularies of the
+// datasets are reloaded in the background after the swap so the new config doesn't wait for them. Connections
+// are not dropped, the current configuration is kept when the new one is invalid.
+func reload(current settings.Config, datasets *service.Datasets, router *swappableHandler, throttle func(http.Handler) http.Handler) (settings.Config, *service.Datasets) {
+	log.Info("Reload signal received, reloading configuration...")
+
+	config, err := settings.Reload()
+	if err != nil {
+		var invalid *settings.ValidationError
+		if errors.As(err, &invalid) {
+			for _, problem := range invalid.Problems {
+				log.Error(problem)
+			}
+			log.Errorf("Keeping the current configuration, %d problems found", len(invalid.Problems))
+		} else {
+			log.Errorf("Keeping the current configuration, reload failed: %v", err)
+		}
+		return current, datasets
+	}
+
+	changes := settings.Diff(current, config)
+	for _, change := range changes {
+		log.Infof("Config changed %s", change)
+	}
+
+	if len(changes) == 0 {
+		log.Info("Configuration has not changed, reloading vocabularies")
+	}
+
+	if config.Server.Port != current.Server.Port {
+		log.Warnf("Still listening on port %d, server.port is applied after a restart", current.Server.Port)
+		config.Server.Port = current.Server.Port
+	}
+
+	if config.Server.MaxConcurrentRequests != current.Server.MaxConcurrentRequests {
+		log.Warnf("Still limited to %d concurrent requests, server.maxConcurrentRequests is applied after a restart", current.Server.MaxConcurrentRequests)
+		config.Server.MaxConcurrentRequests = current.Server.MaxConcurrentRequests
+	}
+
+	if config.Tracing != current.Tracing {
+		log.Warn("Tracing is configured at startup, the tracing changes are applied after a restart")
+		config.Tracing = current.Tracing
+	}
+
+	// Aliases added to the config are found at query time, the loaded aliases change after a create or update
+	service.SetSynonyms(service.NewSynonyms(config.Aliases))
+
+	reconfigured, warnings := datasets.Reconfigure(config)
+	for _, warning := range warnings {
+		log.Warn(warning)
+	}
+
+	log.SetLevel(log.InfoLevel)
+	if config.Server.Debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	router.set(createRouter(config, reconfigured, throttle))
+	log.Info("Configuration reloaded")
+
+	go func() {
+		for _, dataset := range reconfigured.All() {
+			err := service.LoadVocabulary(dataset)
+			if err != nil {
+				log.Errorf("Error reloading vocabulary of dataset %s, the previous vocabulary is kept: %v", dataset.Name, err)
+			}
+		}
+	}()
+
+	return config, reconfigured
+}
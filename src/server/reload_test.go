@@ -0,0 +1,100 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/tebben/geocodeur/service"
+	"github.com/tebben/geocodeur/settings"
+)
+
+const reloadTestConfig = `{
+	"database": {
+		"connectionString": "postgres://geocodeur@127.0.0.1:1/geocodeur"
+	},
+	"ranking": {
+		"weights": { "similarity": 1, "class": %s }
+	}
+}`
+
+func writeConfig(t *testing.T, path string, classWeight string) {
+	t.Helper()
+
+	err := os.WriteFile(path, []byte(strings.Replace(reloadTestConfig, "%s", classWeight, 1)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadRankingWeights(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geocodeur.conf")
+	writeConfig(t, path, "0.5")
+
+	settings.SetConfigLocation(path)
+	if err := settings.InitializeConfig(); err != nil {
+		t.Fatal(err)
+	}
+	config := settings.GetConfig()
+
+	datasets, err := service.OpenDatasets(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig(t, path, "0.25")
+	config, datasets = reload(config, datasets, &swappableHandler{}, chimiddleware.Throttle(config.Server.MaxConcurrentRequests))
+
+	if config.Ranking.Weights.Class != 0.25 {
+		t.Fatalf("class weight = %v, expected 0.25", config.Ranking.Weights.Class)
+	}
+
+	dataset, err := datasets.Get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, ok := dataset.Store.(*service.PostgresStore)
+	if !ok {
+		t.Fatalf("store is %T, expected a PostgreSQL store", dataset.Store)
+	}
+
+	query := store.Query(service.GeocodeOptions{Limit: 10}, service.NewSearchTerms("kerkstraat", nil))
+	if !strings.Contains(query, "-0.25 * a.class_rank") {
+		t.Errorf("score of the query doesn't use the reloaded class weight -0.25:\n%s", query)
+	}
+	if strings.Contains(query, "-0.5 * a.class_rank") {
+		t.Errorf("score of the query still uses the previous class weight -0.5:\n%s", query)
+	}
+}
+
+func TestReloadInvalidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geocodeur.conf")
+	writeConfig(t, path, "0.5")
+
+	settings.SetConfigLocation(path)
+	if err := settings.InitializeConfig(); err != nil {
+		t.Fatal(err)
+	}
+	config := settings.GetConfig()
+
+	datasets, err := service.OpenDatasets(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := filepath.Join(t.TempDir(), "invalid.conf")
+	writeConfig(t, invalid, `"heavy"`)
+	settings.SetConfigLocation(invalid)
+
+	reloaded, _ := reload(config, datasets, &swappableHandler{}, chimiddleware.Throttle(config.Server.MaxConcurrentRequests))
+	if reloaded.Ranking.Weights.Class != 0.5 {
+		t.Errorf("class weight = %v, expected the current weight 0.5", reloaded.Ranking.Weights.Class)
+	}
+
+	if settings.LoadedFile() != path {
+		t.Errorf("loaded file = %s, expected the current file %s", settings.LoadedFile(), path)
+	}
+}
@@ -19,6 +19,9 @@ import (
 	"github.com/tebben/geocodeur/api/handlers"
 	"github.com/tebben/geocodeur/api/middleware"
 	"github.com/tebben/geocodeur/database"
+	"github.com/tebben/geocodeur/errors"
+	"github.com/tebben/geocodeur/metrics"
+	"github.com/tebben/geocodeur/service"
 	"github.com/tebben/geocodeur/settings"
 )
 
@@ -26,14 +29,51 @@ import (
 // It initializes the necessary resources, sets up the main handler,
 // and listens for incoming HTTP requests on the specified port.
 func Start(config settings.Config) {
-	setPgtrmTreshold(config)
+	for _, dataset := range config.Datasets {
+		if dataset.Backend == settings.BackendPostgres {
+			pool, err := database.GetDBPool(context.Background(), dataset.Name, dataset.Database)
+			if err != nil {
+				log.Fatalf("Error connecting to dataset %s: %v", dataset.Name, err)
+			}
+
+			err = database.CheckSchemaVersion(context.Background(), pool, dataset.Database)
+			if err != nil {
+				log.Fatalf("Incompatible database for dataset %s: %v", dataset.Name, err)
+			}
+		}
+	}
 
-	router := createRouter(config)
+	datasets, err := service.OpenDatasets(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	service.SetSynonyms(service.NewSynonyms(config.Aliases))
+	for _, dataset := range datasets.All() {
+		err = service.LoadVocabulary(dataset)
+		if err != nil {
+			log.Errorf("Error loading vocabulary of dataset %s, suggestions are disabled: %v", dataset.Name, err)
+		}
+	}
+
+	// The throttle is shared by the routers swapped in on reload, requests in flight on a previous
+	// router count towards the limit of the new one
+	throttle := chimiddleware.Throttle(config.Server.MaxConcurrentRequests)
+	router := &swappableHandler{}
+	router.set(createRouter(config, datasets, throttle))
 	server := &http.Server{Addr: fmt.Sprintf(":%v", config.Server.Port), Handler: router}
 	serverCtx, serverStopCtx := context.WithCancel(context.Background())
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			config, datasets = reload(config, datasets, router, throttle)
+		}
+	}()
+
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
 		<-sig
 
@@ -61,7 +101,7 @@ func Start(config settings.Config) {
 	log.Info(fmt.Sprintf("Geocodeur started, running on port %v", config.Server.Port))
 	defer database.CloseDBPools()
 
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
@@ -72,12 +112,15 @@ func Start(config settings.Config) {
 
 // createRouter creates and configures the router for the server.
 // It sets up the necessary middleware and routes for handling API requests.
-// The router is configured with the provided `config` settings.
-func createRouter(config settings.Config) http.Handler {
+// The router is configured with the provided `config` settings and serves the given datasets,
+// the throttle limiting the concurrent requests is created once and shared by all routers.
+func createRouter(config settings.Config, datasets *service.Datasets, throttle func(http.Handler) http.Handler) http.Handler {
 	router := chi.NewMux()
+	router.Use(middleware.Metrics())
+	router.Use(middleware.Tracing())
 	router.Use(middleware.Logger("router", log.StandardLogger(), logrus.DebugLevel))
 	router.Use(chimiddleware.Recoverer)
-	router.Use(chimiddleware.Throttle(config.Server.MaxConcurrentRequests))
+	router.Use(throttle)
 	router.Use(chimiddleware.Timeout(time.Duration(config.Server.Timeout) * time.Second))
 	router.Use(chimiddleware.Compress(5, "application/json"))
 	router.Use(cors.Handler(cors.Options{
@@ -91,12 +134,16 @@ func createRouter(config settings.Config) http.Handler {
 
 	humaConfig := createHumaConfig()
 	api := humachi.New(router, humaConfig)
-	registerRoutes(api, config)
+	registerRoutes(api, config, datasets)
+	router.Handle("/metrics", metrics.Handler())
 
 	return router
 }
 
 func createHumaConfig() huma.Config {
+	// Errors of huma, like invalid parameters, are formatted like the errors of the handlers
+	huma.NewError = errors.NewHumaError
+
 	humaConfig := huma.DefaultConfig("Geocodeur", "1.0.0")
 	humaConfig.CreateHooks = nil
 	humaConfig.Info.Contact = &huma.Contact{
@@ -110,7 +157,7 @@ func createHumaConfig() huma.Config {
 	return humaConfig
 }
 
-func registerRoutes(api huma.API, config settings.Config) {
+func registerRoutes(api huma.API, config settings.Config, datasets *service.Datasets) {
 	huma.Register(api, huma.Operation{
 		OperationID: "status",
 		Method:      http.MethodGet,
@@ -119,13 +166,33 @@ func registerRoutes(api huma.API, config settings.Config) {
 		Description: "Get the status of geocodeur.",
 	}, handlers.StatusHandler(time.Now()))
 
+	huma.Register(api, huma.Operation{
+		OperationID: "health-live",
+		Method:      http.MethodGet,
+		Path:        "/health/live",
+		Summary:     "Liveness",
+		Description: "Check that geocodeur is running.",
+	}, handlers.LiveHandler())
+
+	huma.Register(api, huma.Operation{
+		OperationID: "health-ready",
+		Method:      http.MethodGet,
+		Path:        "/health/ready",
+		Summary:     "Readiness",
+		Description: "Check that all datasets can serve requests: the database is reachable, the postgis and pg_trgm extensions are installed, the tables exist and are filled and the schema version is supported. Responds with 503 when a dataset is not ready. The number of features per class, the load date and the Overture release of every dataset are reported as well.",
+		Responses: map[string]*huma.Response{
+			"503": {Description: "A dataset is not ready"},
+		},
+	}, handlers.ReadyHandler(datasets))
+
 	huma.Register(api, huma.Operation{
 		OperationID: "geocode",
 		Method:      http.MethodGet,
 		Path:        "/geocode",
 		Summary:     "Geocode (Free Text Search)",
 		Description: "This endpoint gives you the ability to search for a feature based on free text search.",
-	}, handlers.GeocodeHandler(config))
+		Errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	}, handlers.GeocodeHandler(config, datasets))
 
 	huma.Register(api, huma.Operation{
 		OperationID: "lookup",
@@ -133,15 +200,6 @@ func registerRoutes(api huma.API, config settings.Config) {
 		Path:        "/lookup/{id}",
 		Summary:     "Lookup",
 		Description: "Lookup a feature based on its ID.",
-	}, handlers.LookupHandler(config))
-}
-
-func setPgtrmTreshold(config settings.Config) {
-	pool, err := database.GetDBPool("geocodeur", config.Database)
-	if err != nil {
-		log.Errorf("Error getting database pool: %v", err)
-		return
-	}
-
-	pool.Exec(context.Background(), fmt.Sprintf("ALTER DATABASE %s SET pg_trgm.similarity_threshold = %v;", config.Database.Name, config.API.PGTRGMTreshold))
+		Errors:      []int{http.StatusNotFound, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	}, handlers.LookupHandler(datasets))
 }
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/tebben/geocodeur/metrics"
+	"github.com/tebben/geocodeur/service"
+	"github.com/tebben/geocodeur/settings"
+)
+
+func testRouter(t *testing.T) http.Handler {
+	t.Helper()
+
+	config := settings.Config{
+		Server: settings.ServerConfig{MaxConcurrentRequests: 10, Timeout: 30},
+		API:    settings.APIConfig{PGTRGMTreshold: 0.8},
+		Ranking: settings.RankingConfig{
+			Weights:     settings.RankingWeights{Similarity: 1},
+			DefaultRank: 100,
+			ClassRanks:  map[string]int{"division": 1, "road": 2, "poi": 6},
+		},
+	}
+
+	store := service.NewMemoryStore(config.Ranking)
+	store.Add(service.Feature{ID: 1, Name: "Kerkstraat", Class: "road", Subclass: "residential", Divisions: []string{"Vught"}}, 0.5, "Kerkstraat Vught", "Kerkstraat")
+	store.Add(service.Feature{ID: 2, Name: "Kerkstraat", Class: "road", Subclass: "residential", Divisions: []string{"Amsterdam"}}, 0, "Kerkstraat Amsterdam", "Kerkstraat")
+	store.Add(service.Feature{ID: 3, Name: "Utrecht", Class: "division", Subclass: "locality"}, 0, "Utrecht")
+
+	dataset := &service.Dataset{DatasetConfig: settings.DatasetConfig{Name: "test"}, Store: store, Ranking: config.Ranking}
+	if err := service.LoadVocabulary(dataset); err != nil {
+		t.Fatal(err)
+	}
+
+	return createRouter(config, service.NewDatasets(dataset), chimiddleware.Throttle(config.Server.MaxConcurrentRequests))
+}
+
+func get(t *testing.T, router http.Handler, url string) (int, string, map[string]any) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+
+	var body map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s: invalid response %q: %v", url, recorder.Body.String(), err)
+	}
+
+	return recorder.Code, recorder.Header().Get("Content-Type"), body
+}
+
+func TestGeocode(t *testing.T) {
+	router := testRouter(t)
+
+	tests := []struct {
+		name        string
+		url         string
+		ids         []float64
+		searchType  string
+		suggestions []any
+	}{
+		{"fts", "/geocode?q=kerkstraat%20vught", []float64{1}, "fts", nil},
+		{"fuzzy", "/geocode?q=kerkstrat%20amsterdm", []float64{2}, "fuzzy", nil},
+		{"limit", "/geocode?q=kerkstraat&limit=1", []float64{1}, "fts", nil},
+		{"suggestions", "/geocode?q=kerkstrat%20utrect", nil, "", []any{"kerkstraat utrecht"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := get(t, router, tt.url)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %v", status, body)
+			}
+
+			results, _ := body["results"].([]any)
+			if len(results) != len(tt.ids) {
+				t.Fatalf("got %d results, want %d: %v", len(results), len(tt.ids), results)
+			}
+
+			for i, result := range results {
+				result := result.(map[string]any)
+				if result["id"] != tt.ids[i] || result["searchType"] != tt.searchType {
+					t.Errorf("result %d = %v %v, want %v %v", i, result["id"], result["searchType"], tt.ids[i], tt.searchType)
+				}
+			}
+
+			suggestions, _ := body["suggestions"].([]any)
+			if len(suggestions) != len(tt.suggestions) || (len(suggestions) > 0 && suggestions[0] != tt.suggestions[0]) {
+				t.Errorf("suggestions = %v, want %v", suggestions, tt.suggestions)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	router := testRouter(t)
+
+	status, _, body := get(t, router, "/lookup/3")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", status, body)
+	}
+
+	feature, _ := body["feature"].(map[string]any)
+	if feature["name"] != "Utrecht" || feature["class"] != "division" {
+		t.Errorf("feature = %v, want Utrecht", feature)
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	router := testRouter(t)
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		code   string
+	}{
+		{"unknown feature", "/lookup/999", http.StatusNotFound, "not_found"},
+		{"unknown dataset", "/geocode?q=kerkstraat&dataset=unknown", http.StatusNotFound, "not_found"},
+		{"unknown dataset lookup", "/lookup/1?dataset=unknown", http.StatusNotFound, "not_found"},
+		{"invalid focus", "/geocode?q=kerkstraat&focus=5.29,91", http.StatusBadRequest, "invalid_input"},
+		{"invalid limit", "/geocode?q=kerkstraat&limit=1000", http.StatusUnprocessableEntity, "invalid_input"},
+		{"missing query", "/geocode", http.StatusUnprocessableEntity, "invalid_input"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, contentType, body := get(t, router, tt.url)
+			if status != tt.status {
+				t.Errorf("status = %d, want %d: %v", status, tt.status, body)
+			}
+
+			if contentType != "application/problem+json" {
+				t.Errorf("content type = %s, want application/problem+json", contentType)
+			}
+
+			if body["code"] != tt.code || body["status"] != float64(tt.status) || body["detail"] == "" {
+				t.Errorf("problem = %v, want code %s and status %d", body, tt.code, tt.status)
+			}
+		})
+	}
+}
+
+// timeoutStore is a store of which every search hits the statement timeout.
+type timeoutStore struct {
+	*service.MemoryStore
+}
+
+func (timeoutStore) Search(ctx context.Context, options service.GeocodeOptions, terms service.SearchTerms) ([]service.GeocodeResult, service.ExplainTiming, error) {
+	return nil, service.ExplainTiming{}, &service.Error{Kind: service.KindTimeout, Message: "query stopped"}
+}
+
+func TestGeocodeMetrics(t *testing.T) {
+	config := settings.Config{Server: settings.ServerConfig{MaxConcurrentRequests: 10, Timeout: 30}}
+	dataset := &service.Dataset{DatasetConfig: settings.DatasetConfig{Name: "timeout"}, Store: timeoutStore{service.NewMemoryStore(config.Ranking)}}
+	router := createRouter(config, service.NewDatasets(dataset), chimiddleware.Throttle(config.Server.MaxConcurrentRequests))
+
+	status, _, body := get(t, router, "/geocode?q=kerkstraat")
+	if status != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504: %v", status, body)
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	for _, metric := range []string{
+		`geocodeur_geocode_duration_seconds_count{dataset="timeout",search_type="none",status="504"} 1`,
+		`geocodeur_geocode_results_count{dataset="timeout",status="504"} 1`,
+	} {
+		if !strings.Contains(recorder.Body.String(), metric) {
+			t.Errorf("metrics don't contain %s", metric)
+		}
+	}
+
+	if strings.Contains(recorder.Body.String(), `geocodeur_geocode_empty_results_total{dataset="timeout"}`) {
+		t.Error("failed search is counted as search without results")
+	}
+}
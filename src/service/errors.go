@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrorKind tells why a request to the geocoder failed, the API responds with a status per kind.
+type ErrorKind int
+
+const (
+	// KindInternal is the kind of errors that are not caused by the request or the availability of a dataset.
+	KindInternal ErrorKind = iota
+	// KindInvalidInput is the kind of errors caused by the parameters of the request.
+	KindInvalidInput
+	// KindNotFound is the kind of errors for features or datasets that don't exist.
+	KindNotFound
+	// KindUnavailable is the kind of errors for databases that can't be reached.
+	KindUnavailable
+	// KindTimeout is the kind of errors for queries stopped by the statement timeout or the request deadline.
+	KindTimeout
+)
+
+// Error is an error of the geocoder with the kind of error.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// InvalidInputError returns an error for invalid parameters.
+func InvalidInputError(format string, args ...any) error {
+	return &Error{Kind: KindInvalidInput, Message: fmt.Sprintf(format, args...)}
+}
+
+// NotFoundError returns an error for a feature or dataset that doesn't exist.
+func NotFoundError(format string, args ...any) error {
+	return &Error{Kind: KindNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// Kind returns the kind of an error, KindInternal when it's not an Error.
+func Kind(err error) ErrorKind {
+	var serviceError *Error
+	if errors.As(err, &serviceError) {
+		return serviceError.Kind
+	}
+	return KindInternal
+}
+
+// queryError returns the kind of error of a failed query. A query stopped because the statement timeout
+// or the request deadline was hit fails with a cancellation error of the database or a closed connection
+// that doesn't tell why, the error of the context is returned instead. Queries of a request that was
+// cancelled return the context error as it is.
+func queryError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return &Error{Kind: KindTimeout, Message: "query stopped", Err: ctxErr}
+		}
+		return fmt.Errorf("query stopped: %w", ctxErr)
+	}
+
+	// Cancelled by the statement timeout in the database before the deadline of the request
+	var pgError *pgconn.PgError
+	if errors.As(err, &pgError) && pgError.Code == "57014" {
+		return &Error{Kind: KindTimeout, Message: "query stopped", Err: err}
+	}
+
+	if unavailable(err) {
+		return &Error{Kind: KindUnavailable, Message: "database unavailable", Err: err}
+	}
+
+	return err
+}
+
+// unavailable reports if a query failed because the database can't be reached or refuses connections.
+func unavailable(err error) bool {
+	var connectError *pgconn.ConnectError
+	if errors.As(err, &connectError) {
+		return true
+	}
+
+	var netError net.Error
+	if errors.As(err, &netError) {
+		return true
+	}
+
+	// Connection exceptions, insufficient resources and operator interventions like a shutdown
+	var pgError *pgconn.PgError
+	if errors.As(err, &pgError) {
+		code := pgError.Code
+		return strings.HasPrefix(code, "08") || strings.HasPrefix(code, "53") || code == "57P01" || code == "57P02" || code == "57P03"
+	}
+
+	return false
+}
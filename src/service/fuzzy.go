@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTokenCorrections is the maximum number of corrections a misspelled token is expanded with.
+const maxTokenCorrections = 3
+
+// SearchTerms is the input of a geocode request prepared for searching, tokens that
+// are not known in the vocabulary are expanded with their closest corrections so
+// correct tokens still match as prefix while misspelled tokens match fuzzy.
+type SearchTerms struct {
+	Input     string // The lowercased input with single spaces between the words
+	TSQuery   string // The tsquery used for Full Text Search
+	Corrected string // The input with misspelled tokens replaced by their best correction
+	Fuzzy     bool   // True when one or more tokens were expanded with corrections
+
+	tokens []searchToken
+}
+
+// wordCount returns the number of words of the input, it's compared to the word count of the aliases.
+func (t SearchTerms) wordCount() int {
+	return len(strings.Fields(t.Input))
+}
+
+// charCount returns the number of characters of the input, it's compared to the character count of the aliases.
+func (t SearchTerms) charCount() int {
+	return utf8.RuneCountInString(t.Input)
+}
+
+// searchToken is a token of the tsquery, it matches aliases with a word starting
+// with the lexeme or a word equal to one of its corrections.
+type searchToken struct {
+	lexeme      string
+	corrections []string
+}
+
+// NewSearchTerms creates the search terms for the input, misspelled tokens are
+// corrected with the vocabulary which can be nil.
+func NewSearchTerms(input string, vocabulary *Vocabulary) SearchTerms {
+	input = strings.Join(strings.Fields(strings.ToLower(input)), " ")
+	terms := SearchTerms{Input: input}
+
+	var groups, corrected []string
+	for _, token := range strings.Fields(input) {
+		lexeme := sanitizeLexeme(token)
+		if lexeme == "" {
+			continue
+		}
+
+		options := []string{fmt.Sprintf("'%s':*", lexeme)}
+		best := token
+		st := searchToken{lexeme: lexeme}
+		for i, correction := range vocabulary.TokenCorrections(token, maxTokenCorrections) {
+			if i == 0 {
+				best = correction
+			}
+			options = append(options, fmt.Sprintf("'%s'", sanitizeLexeme(correction)))
+			st.corrections = append(st.corrections, sanitizeLexeme(correction))
+		}
+		terms.tokens = append(terms.tokens, st)
+
+		if len(options) > 1 {
+			terms.Fuzzy = true
+			groups = append(groups, fmt.Sprintf("(%s)", strings.Join(options, " | ")))
+		} else {
+			groups = append(groups, options[0])
+		}
+		corrected = append(corrected, best)
+	}
+
+	terms.TSQuery = strings.Join(groups, " & ")
+	terms.Corrected = strings.Join(corrected, " ")
+
+	return terms
+}
+
+// SearchType returns the search type of results found with Full Text Search.
+func (s SearchTerms) SearchType() string {
+	if s.Fuzzy {
+		return "fuzzy"
+	}
+	return "fts"
+}
+
+// sanitizeLexeme removes the characters with a special meaning in a tsquery.
+func sanitizeLexeme(token string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '&', '|', '!', '(', ')', ':', '*', '\'', '<', '>', '\\':
+			return -1
+		}
+		return r
+	}, token)
+}
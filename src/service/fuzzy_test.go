@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/tebben/geocodeur/settings"
+)
+
+func testRanking() settings.RankingConfig {
+	return settings.RankingConfig{
+		Weights:       settings.RankingWeights{Similarity: 1},
+		DefaultRank:   100,
+		ClassRanks:    map[string]int{"division": 1, "water": 1, "road": 2, "infra": 3, "address": 4, "zipcode": 5, "poi": 6},
+		SubclassRanks: map[string]int{"locality": 1, "county": 2, "neighborhood": 3, "residential": 6},
+	}
+}
+
+func testSearch(t *testing.T, store *MemoryStore, options GeocodeOptions, input string) []GeocodeResult {
+	t.Helper()
+
+	vocabulary, err := store.Words(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.Ranking == nil {
+		options.Ranking = NewRankingModel(store.ranking)
+	}
+
+	results, _, err := store.Search(context.Background(), options, NewSearchTerms(input, NewVocabulary(vocabulary)))
+	if err != nil {
+		t.Fatalf("search %q: %v", input, err)
+	}
+
+	return results
+}
+
+func TestFuzzyMatchesCorrectedInput(t *testing.T) {
+	store := NewMemoryStore(testRanking())
+	store.Add(Feature{ID: 1, Name: "Kerkstraat", Class: "road", Subclass: "residential"}, 0, "Kerkstraat Amsterdam", "Kerkstraat")
+	store.Add(Feature{ID: 2, Name: "Kerkstraat", Class: "road", Subclass: "residential"}, 0, "Kerkstraat Vught", "Kerkstraat")
+	store.Add(Feature{ID: 3, Name: "Amsterdam", Class: "division", Subclass: "locality"}, 0, "Amsterdam")
+	store.Add(Feature{ID: 4, Name: "Café de Kerkstraat", Class: "poi"}, 0, "Café de Kerkstraat Amsterdam")
+
+	options := NewGeocodeOptions(0.3, 10, nil, false, false)
+
+	vocabulary, _ := store.Words(context.Background())
+	terms := NewSearchTerms("kerkst masterdam", NewVocabulary(vocabulary))
+	if terms.Corrected != "kerkst amsterdam" || !terms.Fuzzy {
+		t.Fatalf("corrected input = %q fuzzy %v, want \"kerkst amsterdam\" fuzzy true", terms.Corrected, terms.Fuzzy)
+	}
+
+	ids := func(results []GeocodeResult) []uint64 {
+		var ids []uint64
+		for _, result := range results {
+			ids = append(ids, result.ID)
+		}
+		return ids
+	}
+
+	misspelled := testSearch(t, store, options, "kerkst masterdam")
+	correct := testSearch(t, store, options, "kerkstr amsterdam")
+	if len(misspelled) == 0 || !reflect.DeepEqual(ids(misspelled), ids(correct)) {
+		t.Fatalf("results of \"kerkst masterdam\" = %v, want %v like \"kerkstr amsterdam\"", ids(misspelled), ids(correct))
+	}
+
+	// The misspelled input is scored as the corrected input
+	corrected := testSearch(t, store, options, "kerkst amsterdam")
+	for i := range misspelled {
+		if misspelled[i].SearchType != "fuzzy" {
+			t.Errorf("search type of %d = %s, want fuzzy", misspelled[i].ID, misspelled[i].SearchType)
+		}
+		if misspelled[i].ID != corrected[i].ID || misspelled[i].Similarity != corrected[i].Similarity {
+			t.Errorf("result %d = %d with similarity %v, want %d with similarity %v", i, misspelled[i].ID, misspelled[i].Similarity, corrected[i].ID, corrected[i].Similarity)
+		}
+	}
+}
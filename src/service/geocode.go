@@ -7,11 +7,12 @@ import (
 	"fmt"
 	"math"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
-	log "github.com/sirupsen/logrus"
 	"github.com/tebben/geocodeur/database"
 	"github.com/tebben/geocodeur/settings"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type GeocodeResult struct {
@@ -21,9 +22,46 @@ type GeocodeResult struct {
 	Subclass   string          `json:"subclass" doc:"The subclass of the feature"`
 	Divisions  string          `json:"divisions" doc:"The divisions of the feature"`
 	Alias      string          `json:"alias" doc:"The alias of the feature"`
-	SearchType string          `json:"searchType" doc:"The search type used to find the result, either fts (Full Text Search) or trgm (Trigram matching/fuzzy search)"`
+	SearchType string          `json:"searchType" doc:"The search type used to find the result, either fts (Full Text Search), fuzzy (Full Text Search with corrections for misspelled words) or trgm (Trigram matching/fuzzy search)"`
 	Similarity float64         `json:"similarity" doc:"The similarity score q <-> alias, the higher the better"`
 	Geom       json.RawMessage `json:"geom,omitempty" doc:"The geometry of the feature in GeoJSON format"`
+	Explain    *ResultExplain  `json:"explain,omitempty" doc:"Scoring details of the result, only set when explain is requested"`
+}
+
+// ResultExplain describes how a single result was matched and ranked.
+type ResultExplain struct {
+	Alias          string    `json:"alias" doc:"The alias that matched the query"`
+	TSQuery        string    `json:"tsquery" doc:"The Full Text Search tsquery used to match the alias"`
+	Similarity     float64   `json:"similarity" doc:"Unrounded trigram similarity between the query and the alias"`
+	ClassRank      int       `json:"classRank" doc:"Rank of the class of the feature, lower is better"`
+	SubclassRank   int       `json:"subclassRank" doc:"Rank of the subclass of the feature, lower is better"`
+	Importance     float64   `json:"importance" doc:"Importance of the feature between 0 and 1, higher is better"`
+	WordCountDelta int       `json:"wordCountDelta" doc:"Number of words in the alias minus the number of words in the query"`
+	CharCountDelta int       `json:"charCountDelta" doc:"Number of characters in the alias minus the number of characters in the query"`
+	Score          float64   `json:"score" doc:"Score of the result calculated by the ranking model"`
+	OrderKeys      []float64 `json:"orderKeys" doc:"The keys the results are ordered on: score desc, similarity desc, class rank asc, subclass rank asc, importance desc"`
+}
+
+// QueryExplain contains the query wide details of an explained geocode request.
+type QueryExplain struct {
+	TSQuery string        `json:"tsquery" doc:"The Full Text Search tsquery used for the query"`
+	Timing  ExplainTiming `json:"timing" doc:"Timing breakdown of the geocode request in milliseconds"`
+}
+
+// ExplainTiming is the timing breakdown of a geocode request in milliseconds.
+type ExplainTiming struct {
+	Build   float64 `json:"build" doc:"Time in milliseconds it took to build the SQL query"`
+	Execute float64 `json:"execute" doc:"Time in milliseconds it took to execute the SQL query"`
+	Parse   float64 `json:"parse" doc:"Time in milliseconds it took to read and parse the rows"`
+	Total   float64 `json:"total" doc:"Total time in milliseconds spent in the geocoder"`
+}
+
+// GeocodeResponse contains the results of a geocode request and,
+// when requested, the explanation of how the results were found.
+type GeocodeResponse struct {
+	Results     []GeocodeResult
+	Explain     *QueryExplain
+	Suggestions []string
 }
 
 type Class string
@@ -55,7 +93,7 @@ func StringToClass(s string) (Class, error) {
 	case string(Zipcode):
 		return Zipcode, nil
 	default:
-		return "", fmt.Errorf("class %s not found", s)
+		return "", InvalidInputError("class %s not found", s)
 	}
 }
 
@@ -64,13 +102,21 @@ type GeocodeOptions struct {
 	Limit           uint16
 	Classes         []Class
 	IncludeGeometry bool
+	Explain         bool
+	Focus           *Point
+	Ranking         RankingModel
 }
 
-func (g GeocodeOptions) ClassesToSqlArray() string {
-	classes := g.Classes
-	if classes == nil || len(classes) == 0 {
-		classes = []Class{Division, Road, Water, Poi, Infra, Address, Zipcode}
+// classes returns the classes to search on, all classes when no classes are set.
+func (g GeocodeOptions) classes() []Class {
+	if len(g.Classes) == 0 {
+		return []Class{Division, Road, Water, Poi, Infra, Address, Zipcode}
 	}
+	return g.Classes
+}
+
+func (g GeocodeOptions) ClassesToSqlArray() string {
+	classes := g.classes()
 
 	lowerClasses := make([]string, len(classes))
 	for i, class := range classes {
@@ -81,81 +127,141 @@ func (g GeocodeOptions) ClassesToSqlArray() string {
 }
 
 // new GeocodeOptions with default values
-func NewGeocodeOptions(pgtrmTreshold float64, limit uint16, classes []Class, includeGeom bool) GeocodeOptions {
+func NewGeocodeOptions(pgtrmTreshold float64, limit uint16, classes []Class, includeGeom bool, explain bool) GeocodeOptions {
 	return GeocodeOptions{
 		PgtrgmTreshold:  pgtrmTreshold,
 		Limit:           limit,
 		Classes:         classes,
 		IncludeGeometry: includeGeom,
+		Explain:         explain,
 	}
 }
 
-func Geocode(connectionString string, options GeocodeOptions, input string) ([]GeocodeResult, error) {
-	config := settings.GetConfig()
-	pool, err := database.GetDBPool("geocodeur", config.Database)
+// Geocode searches the features of a dataset matching the input.
+func Geocode(ctx context.Context, dataset *Dataset, options GeocodeOptions, input string) (response GeocodeResponse, err error) {
+	ctx, span := startSpan(ctx, "geocode",
+		attribute.String("geocodeur.dataset", dataset.Name),
+		attribute.String("geocodeur.query", input),
+		attribute.Int("geocodeur.limit", int(options.Limit)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	options.Classes, err = datasetClasses(dataset.DatasetConfig, options.Classes)
 	if err != nil {
-		log.Errorf("Error getting database pool: %v", err)
-		return nil, fmt.Errorf("Error connecting to database")
+		return GeocodeResponse{}, err
 	}
 
-	// Everything for search is lower case so we lowercase the input query
-	input = strings.ToLower(input)
+	// Everything for search is lower case so we lowercase the input query, aliases are replaced by their name
+	vocabulary := GetVocabulary(dataset.Name)
+	terms := NewSearchTerms(GetSynonyms().Expand(input), vocabulary)
 
-	// If incoming request has a different pg_trgm similarity threshold than the current one, set it
-	if options.PgtrgmTreshold != config.API.PGTRGMTreshold {
-		pool.Exec(context.Background(), fmt.Sprintf("SET pg_trgm.similarity_threshold = %v;", options.PgtrgmTreshold))
+	if options.Ranking == nil {
+		options.Ranking = NewRankingModel(dataset.Ranking)
 	}
 
-	// Construct the query
-	query := createGeocodeQuery(options, input)
-
-	// Execute the query
-	rows, err := pool.Query(context.Background(), query, input)
+	timeStart := time.Now()
+	results, timing, err := dataset.Store.Search(ctx, options, terms)
 	if err != nil {
-		return nil, err
+		return GeocodeResponse{}, err
 	}
-	defer rows.Close()
 
-	// Parse the results
-	results, err := parseGeocodeResults(rows)
-	if err != nil {
-		return nil, err
+	searchType := "none"
+	if len(results) > 0 {
+		searchType = results[0].SearchType
+	}
+	span.SetAttributes(attribute.String("geocodeur.search_type", searchType), attribute.Int("geocodeur.results", len(results)))
+
+	response = GeocodeResponse{Results: results}
+	if len(results) == 0 {
+		response.Suggestions = vocabulary.Suggest(terms.Input, 3)
+	}
+
+	if options.Explain {
+		timing.Total = durationToMs(time.Since(timeStart))
+		response.Explain = &QueryExplain{
+			TSQuery: terms.TSQuery,
+			Timing:  timing,
+		}
 	}
 
-	return results, nil
+	return response, nil
 }
 
-func parseGeocodeResults(rows pgx.Rows) ([]GeocodeResult, error) {
+// datasetClasses returns the requested classes that are available in the dataset, all
+// classes of the dataset are returned when no classes are requested.
+func datasetClasses(dataset settings.DatasetConfig, classes []Class) ([]Class, error) {
+	if len(dataset.Classes) == 0 {
+		return classes, nil
+	}
+
+	if len(classes) == 0 {
+		classes = []Class{Division, Road, Water, Poi, Infra, Address, Zipcode}
+	}
+
+	available := make([]Class, 0, len(classes))
+	for _, class := range classes {
+		if dataset.HasClass(string(class)) {
+			available = append(available, class)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil, InvalidInputError("none of the requested classes are available in dataset %s", dataset.Name)
+	}
+
+	return available, nil
+}
+
+func parseGeocodeResults(rows pgx.Rows, options GeocodeOptions, terms SearchTerms) ([]GeocodeResult, error) {
 	var results []GeocodeResult
 
 	for rows.Next() {
 		var name, class, subclass, divisions, alias, search string
 		var id uint64
-		var sim float64
+		var sim, importance, score float64
+		var classRank, subclassRank, wordCount, charCount int
 		var geom sql.NullString // Use NullString to handle cases where geom is excluded
 
-		if err := rows.Scan(&id, &name, &class, &subclass, &divisions, &alias, &search, &sim, &geom); err != nil {
+		if err := rows.Scan(&id, &name, &class, &subclass, &divisions, &alias, &search, &sim, &classRank, &subclassRank, &importance, &wordCount, &charCount, &score, &geom); err != nil {
 			return nil, err
 		}
 
-		sim = math.Round(sim*1000) / 1000
-		results = append(results, GeocodeResult{id, name, class, subclass, divisions, alias, search, sim, json.RawMessage(geom.String)})
+		result := GeocodeResult{id, name, class, subclass, divisions, alias, search, math.Round(sim*1000) / 1000, json.RawMessage(geom.String), nil}
+		if options.Explain {
+			result.Explain = newResultExplain(terms, alias, sim, classRank, subclassRank, importance, wordCount, charCount, score)
+		}
+
+		results = append(results, result)
 	}
 
-	return results, nil
+	return results, rows.Err()
 }
 
-func createGeocodeQuery(options GeocodeOptions, input string) string {
-	classesIn := options.ClassesToSqlArray()
+func newResultExplain(terms SearchTerms, alias string, sim float64, classRank int, subclassRank int, importance float64, wordCount int, charCount int, score float64) *ResultExplain {
+	return &ResultExplain{
+		Alias:          alias,
+		TSQuery:        terms.TSQuery,
+		Similarity:     sim,
+		ClassRank:      classRank,
+		SubclassRank:   subclassRank,
+		Importance:     importance,
+		WordCountDelta: wordCount - terms.wordCount(),
+		CharCountDelta: charCount - terms.charCount(),
+		Score:          score,
+		OrderKeys:      []float64{score, sim, float64(classRank), float64(subclassRank), importance},
+	}
+}
 
-	// workaround for now since we do not have class in the search table
-	classesIn = strings.Replace(classesIn, "'division'", "1", -1)
-	classesIn = strings.Replace(classesIn, "'water'", "1", -1)
-	classesIn = strings.Replace(classesIn, "'road'", "2", -1)
-	classesIn = strings.Replace(classesIn, "'infra'", "3", -1)
-	classesIn = strings.Replace(classesIn, "'address'", "4", -1)
-	classesIn = strings.Replace(classesIn, "'zipcode'", "5", -1)
-	classesIn = strings.Replace(classesIn, "'poi'", "6", -1)
+func durationToMs(d time.Duration) float64 {
+	return math.Round(float64(d.Microseconds())) / 1000
+}
+
+// createGeocodeQuery creates the geocode query, the query takes the input ($1), the tsquery ($2)
+// and the corrected input ($3) as parameters. Similarity is calculated against the corrected input
+// so a misspelled token that matched one of its corrections scores as well as the correct token.
+// The search table has no class column, aliases are filtered on the class of their feature.
+func createGeocodeQuery(options GeocodeOptions, db settings.DatabaseConfig, terms SearchTerms) string {
+	classesIn := options.ClassesToSqlArray()
 
 	// Conditional geometry column
 	geometryColumn := "'' AS geom" // Default to an empty string if geometry is not included
@@ -166,36 +272,41 @@ func createGeocodeQuery(options GeocodeOptions, input string) string {
 	return fmt.Sprintf(`
 		WITH fts AS (
 			SELECT
-				feature_id, alias, class_rank, subclass_rank, 'fts' as search
+				s.feature_id, s.alias, s.class_rank, s.subclass_rank, s.importance, s.word_count, s.char_count, '%[7]s' as search
 			FROM
-				%[1]s
+				%[1]s AS s
+			INNER JOIN
+				%[2]s AS o ON o.id = s.feature_id
 			WHERE
-				ABS(word_count - array_length(string_to_array($1, ' '), 1)) < 3
+				ABS(s.word_count - array_length(string_to_array($1, ' '), 1)) < 3
 			AND
-				ABS(char_count - LENGTH($1)) < 30
+				ABS(s.char_count - LENGTH($1)) < 30
 			AND
-				vector_search @@ to_tsquery('simple', replace($1, ' ', ':* & ') || ':*')
+				s.vector_search @@ to_tsquery('simple', $2)
 			AND
-				class_rank IN %[3]s
+				o.class IN %[3]s
 			ORDER BY
-				class_rank ASC,
-				subclass_rank ASC
+				s.class_rank ASC,
+				s.subclass_rank ASC,
+				s.importance DESC
 			LIMIT 100
 		),
 		trgm AS (
-			SELECT feature_id, alias, class_rank, subclass_rank, 'trgm' as search
-			FROM %[1]s
+			SELECT s.feature_id, s.alias, s.class_rank, s.subclass_rank, s.importance, s.word_count, s.char_count, 'trgm' as search
+			FROM %[1]s AS s
+			INNER JOIN %[2]s AS o ON o.id = s.feature_id
 			WHERE
-				ABS(word_count - array_length(string_to_array($1, ' '), 1)) < 3
+				ABS(s.word_count - array_length(string_to_array($1, ' '), 1)) < 3
 			AND
-				ABS(char_count - LENGTH($1)) < 30
+				ABS(s.char_count - LENGTH($1)) < 30
 			AND
-				alias %% $1
+				s.alias %% $3
 			AND
-				class_rank IN %[3]s
+				o.class IN %[3]s
 			ORDER BY
-				class_rank ASC,
-				subclass_rank ASC
+				s.class_rank ASC,
+				s.subclass_rank ASC,
+				s.importance DESC
 			LIMIT 100
 		),
 		search_results AS (
@@ -212,21 +323,27 @@ func createGeocodeQuery(options GeocodeOptions, input string) string {
 				alias,
 				class_rank,
 				subclass_rank,
-				similarity(alias, $1) AS sim,
+				importance,
+				word_count,
+				char_count,
+				similarity(alias, $3) AS sim,
 				search,
-				ROW_NUMBER() OVER (PARTITION BY feature_id ORDER BY similarity(alias, $1) DESC) AS rnk
+				ROW_NUMBER() OVER (PARTITION BY feature_id ORDER BY similarity(alias, $3) DESC) AS rnk
 			from search_results
 		)
 		SELECT
-			b.id, b.name, b.class, b.subclass, b.divisions::varchar, a.alias, a.search, a.sim, %[4]s
+			b.id, b.name, b.class, b.subclass, b.divisions::varchar, a.alias, a.search, a.sim,
+			a.class_rank, a.subclass_rank, a.importance, a.word_count, a.char_count, %[6]s AS score, %[4]s
 		FROM similarity AS a
 		INNER JOIN
 			%[2]s AS b ON a.feature_id = b.id
 		WHERE a.rnk = 1
 		ORDER by
+			score desc,
 			sim desc,
 			class_rank asc,
-			subclass_rank asc
+			subclass_rank asc,
+			importance desc
 		LIMIT %[5]v;`,
-		database.TABLE_SEARCH, database.TABLE_OVERTURE, classesIn, geometryColumn, options.Limit)
+		database.SearchTable(db), database.OvertureTable(db), classesIn, geometryColumn, options.Limit, options.Ranking.ScoreExpression(options.Focus), terms.SearchType())
 }
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tebben/geocodeur/database"
+	"github.com/tebben/geocodeur/settings"
+)
+
+// statsTTL is how long the feature counts of a dataset are cached, counting a big dataset is slow.
+const statsTTL = 5 * time.Minute
+
+var (
+	statsCache      = make(map[string]cachedStats)
+	statsCacheMutex sync.Mutex
+)
+
+type cachedStats struct {
+	stats   Stats
+	counted time.Time
+}
+
+// DatasetHealth is the readiness of a dataset with its metadata.
+type DatasetHealth struct {
+	Name     string           `json:"name" doc:"Name of the dataset"`
+	Backend  string           `json:"backend" doc:"Storage backend of the dataset"`
+	Ready    bool             `json:"ready" doc:"Whether the dataset can serve requests"`
+	Problems []string         `json:"problems,omitempty" doc:"Problems that keep the dataset from serving requests"`
+	Features int64            `json:"features" doc:"Number of features"`
+	Aliases  int64            `json:"aliases" doc:"Number of aliases"`
+	Classes  map[string]int64 `json:"classes,omitempty" doc:"Number of features per class"`
+	LoadedAt *time.Time       `json:"loadedAt,omitempty" doc:"Time the data was loaded, for SQLite and index files the time the file was written"`
+	Release  string           `json:"release,omitempty" doc:"Overture release of the data when it was recorded while loading"`
+}
+
+// CheckDataset checks if a dataset can serve requests. Datasets in PostgreSQL need a reachable database with the
+// postgis and pg_trgm extensions, filled tables and the right schema version, every dataset needs features.
+func CheckDataset(ctx context.Context, dataset *Dataset) DatasetHealth {
+	health := DatasetHealth{Name: dataset.Name, Backend: dataset.Backend}
+
+	switch dataset.Backend {
+	case settings.BackendPostgres:
+		pool, err := database.GetDBPool(ctx, dataset.Name, dataset.Database)
+		if err != nil {
+			health.Problems = append(health.Problems, err.Error())
+			return health
+		}
+
+		health.Problems = database.CheckDatabase(ctx, pool, dataset.Database)
+		if len(health.Problems) > 0 {
+			return health
+		}
+
+		info, err := database.GetLoadInfo(ctx, pool, dataset.Database)
+		if err != nil {
+			health.Problems = append(health.Problems, err.Error())
+			return health
+		}
+
+		if !info.LoadedAt.IsZero() {
+			health.LoadedAt = &info.LoadedAt
+		}
+		health.Release = info.Release
+	default:
+		file, err := os.Stat(dataset.File)
+		if err != nil {
+			health.Problems = append(health.Problems, fmt.Sprintf("file %s is not readable: %v", dataset.File, err))
+			return health
+		}
+
+		modified := file.ModTime().UTC()
+		health.LoadedAt = &modified
+	}
+
+	stats, err := cachedDatasetStats(ctx, dataset)
+	if err != nil {
+		health.Problems = append(health.Problems, fmt.Sprintf("failed to count features: %v", err))
+		return health
+	}
+
+	health.Features = stats.Features
+	health.Aliases = stats.Aliases
+	health.Classes = stats.Classes
+	if stats.Features == 0 {
+		health.Problems = append(health.Problems, "dataset has no features")
+	}
+
+	health.Ready = len(health.Problems) == 0
+	return health
+}
+
+// cachedDatasetStats returns the stats of a dataset counted at most statsTTL ago.
+func cachedDatasetStats(ctx context.Context, dataset *Dataset) (Stats, error) {
+	statsCacheMutex.Lock()
+	cached, ok := statsCache[dataset.Name]
+	statsCacheMutex.Unlock()
+	if ok && time.Since(cached.counted) < statsTTL {
+		return cached.stats, nil
+	}
+
+	stats, err := DatasetStats(ctx, dataset)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	statsCacheMutex.Lock()
+	statsCache[dataset.Name] = cachedStats{stats: stats, counted: time.Now()}
+	statsCacheMutex.Unlock()
+
+	return stats, nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tebben/geocodeur/geometry"
+	"github.com/tebben/geocodeur/index"
+	"github.com/tebben/geocodeur/settings"
+	"github.com/tebben/geocodeur/text"
+)
+
+// IndexStore searches an index file built with index build, without a database. Candidates are
+// found with the word and trigram postings of the index and matched and ranked in Go with the same
+// semantics as the Postgres query, distances to the focus point are calculated to the bounds of the geometries.
+type IndexStore struct {
+	index   *index.Index
+	ranking settings.RankingConfig
+}
+
+// NewIndexStore memory-maps an index file.
+func NewIndexStore(path string, ranking settings.RankingConfig) (*IndexStore, error) {
+	ix, err := index.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IndexStore{index: ix, ranking: ranking}, nil
+}
+
+func (s *IndexStore) Search(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]GeocodeResult, ExplainTiming, error) {
+	return searchCandidates(ctx, s, options, s.ranking, terms)
+}
+
+func (s *IndexStore) Lookup(ctx context.Context, id uint64) (LookupResult, error) {
+	feature, ok := s.index.Feature(id, true)
+	if !ok {
+		return LookupResult{}, NotFoundError("feature %d not found", id)
+	}
+
+	return LookupResult{
+		ID:        feature.ID,
+		Name:      feature.Name,
+		Class:     feature.Class,
+		Subclass:  feature.Subclass,
+		Divisions: strings.Join(feature.Divisions, ","),
+		Geom:      feature.Geom,
+	}, nil
+}
+
+// Words returns the words of the index with the number of aliases they occur in.
+func (s *IndexStore) Words(ctx context.Context) (map[string]int, error) {
+	words := make(map[string]int)
+	s.index.Words(func(word string, count int) {
+		words[word] = count
+	})
+
+	return words, nil
+}
+
+func (s *IndexStore) Reverse(ctx context.Context, options ReverseOptions) ([]ReverseResult, error) {
+	return reverseFeatures(ctx, s, options)
+}
+
+func (s *IndexStore) Stats(ctx context.Context) (Stats, error) {
+	stats := Stats{Features: int64(s.index.FeatureCount()), Aliases: int64(s.index.AliasCount()), Classes: make(map[string]int64)}
+	for id := uint64(1); id <= uint64(s.index.FeatureCount()); id++ {
+		feature, _ := s.index.Feature(id, false)
+		stats.Classes[feature.Class]++
+	}
+
+	return stats, nil
+}
+
+// ftsCandidates finds the aliases with a word starting with every part of a token or
+// equal to one of its corrections.
+func (s *IndexStore) ftsCandidates(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]Candidate, error) {
+	var found []uint32
+	first := true
+	for _, token := range terms.tokens {
+		parts := text.Words(token.lexeme)
+		if len(parts) == 0 {
+			continue
+		}
+
+		matched := s.index.PrefixPostings(parts[0])
+		for _, part := range parts[1:] {
+			matched = intersectPostings(matched, s.index.PrefixPostings(part))
+		}
+
+		for _, correction := range token.corrections {
+			matched = append(matched, s.index.WordPostings(correction)...)
+		}
+		slices.Sort(matched)
+		matched = slices.Compact(matched)
+
+		if first {
+			found = matched
+			first = false
+		} else {
+			found = intersectPostings(found, matched)
+		}
+	}
+
+	return s.candidates(found), nil
+}
+
+// trgmCandidates finds the aliases sharing the most trigrams with the corrected input.
+func (s *IndexStore) trgmCandidates(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]Candidate, error) {
+	shared := make(map[uint32]int)
+	for trigram := range trigramSet(terms.Corrected) {
+		for _, n := range s.index.TrigramPostings(trigram) {
+			shared[n]++
+		}
+	}
+
+	found := make([]uint32, 0, len(shared))
+	for n := range shared {
+		found = append(found, n)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if shared[found[i]] != shared[found[j]] {
+			return shared[found[i]] > shared[found[j]]
+		}
+		return found[i] < found[j]
+	})
+
+	if len(found) > trgmCandidateLimit {
+		found = found[:trgmCandidateLimit]
+	}
+
+	return s.candidates(found), nil
+}
+
+func (s *IndexStore) candidates(found []uint32) []Candidate {
+	candidates := make([]Candidate, len(found))
+	for i, n := range found {
+		alias := s.index.Alias(n)
+		candidates[i] = Candidate{
+			FeatureID:    alias.FeatureID,
+			Class:        s.index.FeatureClass(alias.FeatureID),
+			Alias:        alias.Alias,
+			ClassRank:    alias.ClassRank,
+			SubclassRank: alias.SubclassRank,
+			Importance:   alias.Importance,
+			WordCount:    alias.WordCount,
+			CharCount:    alias.CharCount,
+		}
+	}
+
+	return candidates
+}
+
+func (s *IndexStore) features(ctx context.Context, ids []uint64, includeGeometry bool) (map[uint64]Feature, error) {
+	features := make(map[uint64]Feature, len(ids))
+	for _, id := range ids {
+		feature, ok := s.index.Feature(id, includeGeometry)
+		if !ok {
+			continue
+		}
+
+		features[id] = Feature{
+			ID:        feature.ID,
+			Name:      feature.Name,
+			Class:     feature.Class,
+			Subclass:  feature.Subclass,
+			Divisions: feature.Divisions,
+			Geom:      feature.Geom,
+			Bounds:    feature.Bounds,
+		}
+	}
+
+	return features, nil
+}
+
+func (s *IndexStore) featuresWithin(ctx context.Context, box geometry.Bounds) ([]Feature, error) {
+	features, err := s.features(ctx, s.index.Intersects(box), false)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Feature, 0, len(features))
+	for _, feature := range features {
+		result = append(result, feature)
+	}
+
+	return result, nil
+}
+
+// intersectPostings returns the alias numbers in both ascending lists.
+func intersectPostings(a []uint32, b []uint32) []uint32 {
+	var result []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+
+	return result
+}
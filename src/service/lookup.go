@@ -7,9 +7,9 @@ import (
 	"fmt"
 
 	"github.com/jackc/pgx/v5"
-	log "github.com/sirupsen/logrus"
 	"github.com/tebben/geocodeur/database"
 	"github.com/tebben/geocodeur/settings"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type LookupResult struct {
@@ -21,27 +21,12 @@ type LookupResult struct {
 	Geom      json.RawMessage `json:"geom" doc:"The geometry of the feature in GeoJSON format"`
 }
 
-func Lookup(connectionString string, id uint64) (LookupResult, error) {
-	config := settings.GetConfig()
-	pool, err := database.GetDBPool("geocodeur", config.Database)
-	if err != nil {
-		log.Errorf("Error getting database pool: %v", err)
-		return LookupResult{}, fmt.Errorf("Error connecting to database")
-	}
-
-	// Construct the query
-	query := createLookupQuery()
+// Lookup returns the feature of a dataset with the given id.
+func Lookup(ctx context.Context, dataset *Dataset, id uint64) (result LookupResult, err error) {
+	ctx, span := startSpan(ctx, "lookup", attribute.String("geocodeur.dataset", dataset.Name), attribute.Int64("geocodeur.id", int64(id)))
+	defer func() { endSpan(span, err) }()
 
-	// Execute the query
-	row := pool.QueryRow(context.Background(), query, id)
-
-	// Parse the results
-	result, err := parseLookupResults(row)
-	if err != nil {
-		return LookupResult{}, err
-	}
-
-	return result, nil
+	return dataset.Store.Lookup(ctx, id)
 }
 
 func parseLookupResults(row pgx.Row) (LookupResult, error) {
@@ -65,7 +50,7 @@ func parseLookupResults(row pgx.Row) (LookupResult, error) {
 	return result, nil
 }
 
-func createLookupQuery() string {
+func createLookupQuery(config settings.DatabaseConfig) string {
 	return fmt.Sprintf(`
 			SELECT
 				id,
@@ -78,5 +63,5 @@ func createLookupQuery() string {
 				%s
 			WHERE
 				id = $1;`,
-		database.TABLE_OVERTURE)
+		database.OvertureTable(config))
 }
@@ -0,0 +1,343 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tebben/geocodeur/geometry"
+	"github.com/tebben/geocodeur/settings"
+	"github.com/tebben/geocodeur/text"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// candidateLimit is the maximum number of aliases ranked per search, like the LIMIT in the geocode query.
+const candidateLimit = 100
+
+// Candidate is an alias found by a store that ranks in Go, it has the columns of the search table and
+// the class of its feature.
+type Candidate struct {
+	FeatureID    uint64
+	Class        string
+	Alias        string
+	ClassRank    int
+	SubclassRank int
+	Importance   float64
+	WordCount    int
+	CharCount    int
+}
+
+// Feature is a feature of a store that ranks in Go.
+type Feature struct {
+	ID        uint64
+	Name      string
+	Class     string
+	Subclass  string
+	Divisions []string
+	Geom      json.RawMessage
+	Bounds    geometry.Bounds
+}
+
+// candidateSource is implemented by the stores without SQL ranking, the candidates they
+// find are filtered and ranked by searchCandidates the same way the Postgres query does.
+type candidateSource interface {
+	// ftsCandidates returns the aliases that can match every token of the terms, aliases that
+	// do not match or are not of the classes of the options are filtered out by the caller.
+	ftsCandidates(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]Candidate, error)
+	// trgmCandidates returns the aliases sharing trigrams with the corrected input.
+	trgmCandidates(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]Candidate, error)
+	// features returns the features with the given ids, geometries are only read when includeGeometry is set.
+	features(ctx context.Context, ids []uint64, includeGeometry bool) (map[uint64]Feature, error)
+	// featuresWithin returns the features with bounds intersecting the box without their geometries.
+	featuresWithin(ctx context.Context, box geometry.Bounds) ([]Feature, error)
+}
+
+type rankedCandidate struct {
+	Candidate
+	sim   float64
+	score float64
+}
+
+// searchCandidates matches and ranks the candidates of a source like the geocode query: aliases
+// matching the tsquery are used and aliases similar to the corrected input when none match, the best
+// alias of every feature is scored and the results are ordered by score, similarity, class rank,
+// subclass rank and importance. Results are scored with the ranking when the options have no ranking model.
+func searchCandidates(ctx context.Context, source candidateSource, options GeocodeOptions, ranking settings.RankingConfig, terms SearchTerms) ([]GeocodeResult, ExplainTiming, error) {
+	var timing ExplainTiming
+	timeStart := time.Now()
+
+	if options.Ranking == nil {
+		options.Ranking = NewRankingModel(ranking)
+	}
+
+	classes := make(map[string]bool)
+	for _, class := range options.classes() {
+		classes[strings.ToLower(string(class))] = true
+	}
+
+	inputWords := terms.wordCount()
+	inputChars := terms.charCount()
+	filter := func(c Candidate) bool {
+		return abs(c.WordCount-inputWords) < 3 && abs(c.CharCount-inputChars) < 30 && classes[c.Class]
+	}
+
+	search := terms.SearchType()
+	_, span := startSpan(ctx, "match candidates", attribute.String("geocodeur.search_type", search))
+	candidates, err := source.ftsCandidates(ctx, options, terms)
+	if err != nil {
+		endSpan(span, err)
+		return nil, timing, queryError(ctx, err)
+	}
+
+	found := selectCandidates(candidates, func(c Candidate) bool {
+		return filter(c) && terms.matches(c.Alias)
+	})
+	span.SetAttributes(attribute.Int("geocodeur.candidates", len(candidates)), attribute.Int("geocodeur.matches", len(found)))
+	span.End()
+
+	if len(found) == 0 {
+		search = "trgm"
+		_, span = startSpan(ctx, "match candidates", attribute.String("geocodeur.search_type", search))
+		candidates, err = source.trgmCandidates(ctx, options, terms)
+		if err != nil {
+			endSpan(span, err)
+			return nil, timing, queryError(ctx, err)
+		}
+
+		found = selectCandidates(candidates, func(c Candidate) bool {
+			return filter(c) && similarity(c.Alias, terms.Corrected) >= options.PgtrgmTreshold
+		})
+		span.SetAttributes(attribute.Int("geocodeur.candidates", len(candidates)), attribute.Int("geocodeur.matches", len(found)))
+		span.End()
+	}
+	timeExecute := time.Now()
+
+	// Matching in Go doesn't stop on its own when the request is done
+	if err := ctx.Err(); err != nil {
+		return nil, timing, queryError(ctx, err)
+	}
+
+	_, span = startSpan(ctx, "rank results")
+	defer span.End()
+
+	// Keep the most similar alias of every feature
+	best := make(map[uint64]*rankedCandidate)
+	for _, c := range found {
+		sim := similarity(c.Alias, terms.Corrected)
+		if current, ok := best[c.FeatureID]; !ok || sim > current.sim {
+			best[c.FeatureID] = &rankedCandidate{Candidate: c, sim: sim}
+		}
+	}
+
+	ids := make([]uint64, 0, len(best))
+	for id := range best {
+		ids = append(ids, id)
+	}
+
+	features, err := source.features(ctx, ids, options.IncludeGeometry)
+	if err != nil {
+		return nil, timing, queryError(ctx, err)
+	}
+
+	ranked := make([]*rankedCandidate, 0, len(best))
+	for id, c := range best {
+		feature, ok := features[id]
+		if !ok {
+			continue
+		}
+
+		distance := 0.0
+		if options.Focus != nil {
+			distance = feature.Bounds.DistanceKm(options.Focus.Lon, options.Focus.Lat)
+		}
+
+		c.score = options.Ranking.Score(c.sim, c.ClassRank, c.SubclassRank, c.Importance, distance)
+		ranked = append(ranked, c)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.sim != b.sim {
+			return a.sim > b.sim
+		}
+		return compareRank(a.Candidate, b.Candidate) < 0
+	})
+
+	if len(ranked) > int(options.Limit) {
+		ranked = ranked[:options.Limit]
+	}
+
+	results := make([]GeocodeResult, 0, len(ranked))
+	for _, c := range ranked {
+		feature := features[c.FeatureID]
+		result := GeocodeResult{
+			ID:         feature.ID,
+			Name:       feature.Name,
+			Class:      feature.Class,
+			Subclass:   feature.Subclass,
+			Divisions:  textArray(feature.Divisions),
+			Alias:      c.Alias,
+			SearchType: search,
+			Similarity: math.Round(c.sim*1000) / 1000,
+		}
+
+		if options.IncludeGeometry {
+			result.Geom = feature.Geom
+		}
+
+		if options.Explain {
+			result.Explain = newResultExplain(terms, c.Alias, c.sim, c.ClassRank, c.SubclassRank, c.Importance, c.WordCount, c.CharCount, c.score)
+		}
+
+		results = append(results, result)
+	}
+	timeParse := time.Now()
+
+	timing.Execute = durationToMs(timeExecute.Sub(timeStart))
+	timing.Parse = durationToMs(timeParse.Sub(timeExecute))
+
+	return results, timing, nil
+}
+
+// selectCandidates returns the first candidateLimit candidates accepted by the filter
+// ordered by class rank, subclass rank and importance.
+func selectCandidates(candidates []Candidate, accept func(c Candidate) bool) []Candidate {
+	var selected []Candidate
+	for _, c := range candidates {
+		if accept(c) {
+			selected = append(selected, c)
+		}
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		return compareRank(selected[i], selected[j]) < 0
+	})
+
+	if len(selected) > candidateLimit {
+		selected = selected[:candidateLimit]
+	}
+
+	return selected
+}
+
+func compareRank(a Candidate, b Candidate) int {
+	if a.ClassRank != b.ClassRank {
+		return a.ClassRank - b.ClassRank
+	}
+	if a.SubclassRank != b.SubclassRank {
+		return a.SubclassRank - b.SubclassRank
+	}
+	if a.Importance != b.Importance {
+		if a.Importance > b.Importance {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// matches checks if the alias matches the tsquery of the terms, every token has to be
+// the prefix of a word in the alias or equal to a word when it's one of its corrections.
+func (s SearchTerms) matches(alias string) bool {
+	if len(s.tokens) == 0 {
+		return false
+	}
+
+	words := text.Words(alias)
+	for _, token := range s.tokens {
+		if !token.matches(words) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t searchToken) matches(words []string) bool {
+	// A lexeme like "s-hertogenbosch" is split in the parts the parser of Postgres finds
+	matched := true
+	for _, part := range text.Words(t.lexeme) {
+		if !containsPrefix(words, part) {
+			matched = false
+			break
+		}
+	}
+
+	if matched {
+		return true
+	}
+
+	for _, correction := range t.corrections {
+		for _, word := range words {
+			if word == correction {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func containsPrefix(words []string, prefix string) bool {
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// similarity returns the trigram similarity of a and b like similarity() of pg_trgm does.
+func similarity(a string, b string) float64 {
+	ta, tb := trigramSet(a), trigramSet(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for trigram := range ta {
+		if tb[trigram] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+func trigramSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range text.Words(s) {
+		for _, trigram := range text.Trigrams(word) {
+			set[trigram] = true
+		}
+	}
+
+	return set
+}
+
+// textArray formats values like a text array cast to varchar in Postgres.
+func textArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		if value == "" || strings.ContainsAny(value, " ,{}\"\\") || strings.EqualFold(value, "null") {
+			value = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+		}
+		quoted[i] = value
+	}
+
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
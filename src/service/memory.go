@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tebben/geocodeur/geometry"
+	"github.com/tebben/geocodeur/settings"
+	"github.com/tebben/geocodeur/text"
+)
+
+// MemoryStore keeps the features and aliases of a dataset in memory, it's meant for tests and tiny
+// datasets. Every alias is a candidate, they are matched and ranked in Go with the same semantics
+// as the Postgres query.
+type MemoryStore struct {
+	byID    map[uint64]Feature
+	aliases []Candidate
+	ranking settings.RankingConfig
+}
+
+// NewMemoryStore creates an empty store, class and subclass ranks are taken from the ranking config.
+func NewMemoryStore(ranking settings.RankingConfig) *MemoryStore {
+	return &MemoryStore{byID: make(map[uint64]Feature), ranking: ranking}
+}
+
+// Add adds a feature with the aliases to search it on, aliases are lower cased like they are when loading.
+func (s *MemoryStore) Add(feature Feature, importance float64, aliases ...string) {
+	s.byID[feature.ID] = feature
+	for _, alias := range aliases {
+		alias = strings.ToLower(alias)
+		s.aliases = append(s.aliases, Candidate{
+			FeatureID:    feature.ID,
+			Class:        feature.Class,
+			Alias:        alias,
+			ClassRank:    s.ranking.ClassRank(feature.Class),
+			SubclassRank: s.ranking.SubclassRank(feature.Subclass),
+			Importance:   importance,
+			WordCount:    len(strings.Fields(alias)),
+			CharCount:    utf8.RuneCountInString(alias),
+		})
+	}
+}
+
+func (s *MemoryStore) Search(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]GeocodeResult, ExplainTiming, error) {
+	return searchCandidates(ctx, s, options, s.ranking, terms)
+}
+
+func (s *MemoryStore) Lookup(ctx context.Context, id uint64) (LookupResult, error) {
+	feature, ok := s.byID[id]
+	if !ok {
+		return LookupResult{}, NotFoundError("feature %d not found", id)
+	}
+
+	return LookupResult{
+		ID:        feature.ID,
+		Name:      feature.Name,
+		Class:     feature.Class,
+		Subclass:  feature.Subclass,
+		Divisions: strings.Join(feature.Divisions, ","),
+		Geom:      feature.Geom,
+	}, nil
+}
+
+// Words counts the aliases every word occurs in.
+func (s *MemoryStore) Words(ctx context.Context) (map[string]int, error) {
+	words := make(map[string]int)
+	for _, alias := range s.aliases {
+		seen := make(map[string]bool)
+		for _, word := range text.Words(alias.Alias) {
+			if !seen[word] {
+				seen[word] = true
+				words[word]++
+			}
+		}
+	}
+
+	return words, nil
+}
+
+func (s *MemoryStore) Reverse(ctx context.Context, options ReverseOptions) ([]ReverseResult, error) {
+	return reverseFeatures(ctx, s, options)
+}
+
+func (s *MemoryStore) Stats(ctx context.Context) (Stats, error) {
+	stats := Stats{Features: int64(len(s.byID)), Aliases: int64(len(s.aliases)), Classes: make(map[string]int64)}
+	for _, feature := range s.byID {
+		stats.Classes[feature.Class]++
+	}
+
+	return stats, nil
+}
+
+func (s *MemoryStore) ftsCandidates(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]Candidate, error) {
+	return s.aliases, nil
+}
+
+func (s *MemoryStore) trgmCandidates(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]Candidate, error) {
+	return s.aliases, nil
+}
+
+func (s *MemoryStore) features(ctx context.Context, ids []uint64, includeGeometry bool) (map[uint64]Feature, error) {
+	features := make(map[uint64]Feature, len(ids))
+	for _, id := range ids {
+		feature, ok := s.byID[id]
+		if !ok {
+			continue
+		}
+
+		if !includeGeometry {
+			feature.Geom = nil
+		}
+		features[id] = feature
+	}
+
+	return features, nil
+}
+
+func (s *MemoryStore) featuresWithin(ctx context.Context, box geometry.Bounds) ([]Feature, error) {
+	var features []Feature
+	for _, feature := range s.byID {
+		b := feature.Bounds
+		if b.MinLon <= box.MaxLon && box.MinLon <= b.MaxLon && b.MinLat <= box.MaxLat && box.MinLat <= b.MaxLat {
+			feature.Geom = nil
+			features = append(features, feature)
+		}
+	}
+
+	return features, nil
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tebben/geocodeur/geometry"
+	"github.com/tebben/geocodeur/settings"
+)
+
+func testStore() *MemoryStore {
+	vught := geometry.Bounds{MinLon: 5.29, MinLat: 51.65, MaxLon: 5.30, MaxLat: 51.66}
+	amsterdam := geometry.Bounds{MinLon: 4.88, MinLat: 52.36, MaxLon: 4.89, MaxLat: 52.37}
+
+	store := NewMemoryStore(testRanking())
+	store.Add(Feature{ID: 1, Name: "Kerkstraat", Class: "road", Subclass: "residential", Divisions: []string{"Vught"}, Bounds: vught}, 0, "Kerkstraat Vught", "Kerkstraat")
+	store.Add(Feature{ID: 2, Name: "Kerkstraat", Class: "road", Subclass: "residential", Divisions: []string{"Amsterdam"}, Bounds: amsterdam}, 0.5, "Kerkstraat Amsterdam", "Kerkstraat")
+	store.Add(Feature{ID: 3, Name: "Kerkstraat", Class: "division", Subclass: "neighborhood", Divisions: []string{"Amsterdam"}, Bounds: amsterdam}, 0, "Kerkstraat Amsterdam", "Kerkstraat")
+	store.Add(Feature{ID: 4, Name: "Kerkstraat", Class: "poi", Subclass: "cafe", Divisions: []string{"Amsterdam"}, Bounds: amsterdam}, 0, "Kerkstraat Amsterdam", "Kerkstraat")
+	store.Add(Feature{ID: 5, Name: "Vught", Class: "division", Subclass: "locality", Bounds: vught}, 0, "Vught")
+	store.Add(Feature{ID: 6, Name: "Amsterdam", Class: "division", Subclass: "locality", Bounds: amsterdam}, 1, "Amsterdam")
+
+	return store
+}
+
+func TestSearchCandidatesRanking(t *testing.T) {
+	store := testStore()
+	distance := WeightedRanking{Weights: settings.RankingWeights{Similarity: 1, Distance: 0.01}}
+
+	tests := []struct {
+		name       string
+		input      string
+		classes    []Class
+		focus      *Point
+		ranking    RankingModel
+		want       []uint64
+		searchType string
+	}{
+		{"class rank", "kerkstraat", nil, nil, nil, []uint64{3, 2, 1, 4}, "fts"},
+		{"importance breaks ties", "kerkstraat", []Class{Road}, nil, nil, []uint64{2, 1}, "fts"},
+		{"classes filter", "kerkstraat", []Class{Poi}, nil, nil, []uint64{4}, "fts"},
+		{"classes sharing a rank", "vught", []Class{Water}, nil, nil, nil, ""},
+		{"prefix", "kerkstr vu", nil, nil, nil, []uint64{1}, "fts"},
+		{"fuzzy", "kerkstrat vugt", nil, nil, nil, []uint64{1}, "fuzzy"},
+		{"trigram", "kerkstraat vught xq", nil, nil, nil, []uint64{1}, "trgm"},
+		{"focus in amsterdam", "kerkstraat", []Class{Road}, &Point{Lon: 4.9, Lat: 52.37}, distance, []uint64{2, 1}, "fts"},
+		{"focus in vught", "kerkstraat", []Class{Road}, &Point{Lon: 5.3, Lat: 51.66}, distance, []uint64{1, 2}, "fts"},
+		{"no match", "zzzzzz", nil, nil, nil, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := NewGeocodeOptions(0.3, 10, tt.classes, false, false)
+			options.Focus = tt.focus
+			options.Ranking = tt.ranking
+
+			results := testSearch(t, store, options, tt.input)
+			if len(results) < len(tt.want) || (len(tt.want) == 0 && len(results) > 0) {
+				t.Fatalf("search %q returned %d results, want at least %d", tt.input, len(results), len(tt.want))
+			}
+
+			var ids []uint64
+			for _, result := range results[:len(tt.want)] {
+				ids = append(ids, result.ID)
+				if result.SearchType != tt.searchType {
+					t.Errorf("search type of %d = %s, want %s", result.ID, result.SearchType, tt.searchType)
+				}
+			}
+
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("search %q = %v, want %v", tt.input, ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestExplainCountDeltas(t *testing.T) {
+	store := NewMemoryStore(testRanking())
+	store.Add(Feature{ID: 1, Name: "Düsseldorf", Class: "division", Subclass: "locality"}, 0, "Düsseldorf")
+	store.Add(Feature{ID: 2, Name: "Curaçao", Class: "division", Subclass: "locality"}, 0, "Curaçao Willemstad")
+	store.Add(Feature{ID: 3, Name: "Sint-Michielsgestel", Class: "division", Subclass: "locality"}, 0, " Sint  Michielsgestel")
+
+	tests := []struct {
+		input     string
+		wordDelta int
+		charDelta int
+	}{
+		{"Düsseldorf", 0, 0},
+		{"  düsseldorf ", 0, 0},
+		{"curaçao", 1, 11},
+		{"Curaçao  Willemstad", 0, 0},
+		{"sint michielsgestel", 0, 2},
+	}
+
+	for _, tt := range tests {
+		options := NewGeocodeOptions(0.3, 10, nil, false, true)
+		results := testSearch(t, store, options, tt.input)
+		if len(results) == 0 {
+			t.Fatalf("search %q returned no results", tt.input)
+		}
+
+		explain := results[0].Explain
+		if explain.WordCountDelta != tt.wordDelta || explain.CharCountDelta != tt.charDelta {
+			t.Errorf("search %q: word and char count delta = %d %d, want %d %d", tt.input, explain.WordCountDelta, explain.CharCountDelta, tt.wordDelta, tt.charDelta)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+	"github.com/tebben/geocodeur/database"
+	"github.com/tebben/geocodeur/settings"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// vocabularyTimeout limits reading the words of all aliases, which scans the whole search table.
+const vocabularyTimeout = 5 * time.Minute
+
+// PostgresStore searches the overture and search tables in PostGIS, results are
+// matched with Full Text Search and pg_trgm and ranked in SQL.
+type PostgresStore struct {
+	dataset settings.DatasetConfig
+	ranking settings.RankingConfig
+	timeout time.Duration
+}
+
+// NewPostgresStore creates a store for a dataset in PostgreSQL with the statement timeout of the api config.
+func NewPostgresStore(dataset settings.DatasetConfig, ranking settings.RankingConfig, api settings.APIConfig) *PostgresStore {
+	return &PostgresStore{
+		dataset: dataset,
+		ranking: ranking,
+		timeout: time.Duration(api.StatementTimeout) * time.Millisecond,
+	}
+}
+
+func (s *PostgresStore) Search(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]GeocodeResult, ExplainTiming, error) {
+	var timing ExplainTiming
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	pool, err := database.GetDBPool(ctx, s.dataset.Name, s.dataset.Database)
+	if err != nil {
+		log.Errorf("Error getting database pool: %v", err)
+		return nil, timing, queryError(ctx, &Error{Kind: KindUnavailable, Message: "Error connecting to database"})
+	}
+
+	tx, err := begin(ctx, pool, s.timeout)
+	if err != nil {
+		return nil, timing, queryError(ctx, err)
+	}
+	defer tx.Rollback(context.Background())
+
+	// The threshold of the % operator is set for this transaction only, a SET on the pool would stay
+	// on the connection and be used by the next requests
+	_, err = tx.Exec(ctx, fmt.Sprintf("SET LOCAL pg_trgm.similarity_threshold = %v;", options.PgtrgmTreshold))
+	if err != nil {
+		return nil, timing, queryError(ctx, err)
+	}
+
+	// Construct the query
+	timeStart := time.Now()
+	_, span := startSpan(ctx, "build query")
+	query := s.Query(options, terms)
+	span.SetAttributes(attribute.String("geocodeur.tsquery", terms.TSQuery))
+	span.End()
+	timeBuild := time.Now()
+
+	// Execute the query
+	rows, err := tx.Query(ctx, query, terms.Input, terms.TSQuery, terms.Corrected)
+	if err != nil {
+		return nil, timing, queryError(ctx, err)
+	}
+	defer rows.Close()
+	timeExecute := time.Now()
+
+	// Parse the results
+	_, span = startSpan(ctx, "parse results")
+	results, err := parseGeocodeResults(rows, options, terms)
+	span.SetAttributes(attribute.Int("geocodeur.results", len(results)))
+	endSpan(span, err)
+	if err != nil {
+		return nil, timing, queryError(ctx, err)
+	}
+	timeParse := time.Now()
+
+	timing = ExplainTiming{
+		Build:   durationToMs(timeBuild.Sub(timeStart)),
+		Execute: durationToMs(timeExecute.Sub(timeBuild)),
+		Parse:   durationToMs(timeParse.Sub(timeExecute)),
+	}
+
+	return results, timing, nil
+}
+
+// Query returns the geocode query of the search terms, results are scored with the ranking of the store
+// when the options have no ranking model.
+func (s *PostgresStore) Query(options GeocodeOptions, terms SearchTerms) string {
+	if options.Ranking == nil {
+		options.Ranking = NewRankingModel(s.ranking)
+	}
+
+	return createGeocodeQuery(options, s.dataset.Database, terms)
+}
+
+func (s *PostgresStore) Lookup(ctx context.Context, id uint64) (LookupResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	pool, err := database.GetDBPool(ctx, s.dataset.Name, s.dataset.Database)
+	if err != nil {
+		log.Errorf("Error getting database pool: %v", err)
+		return LookupResult{}, queryError(ctx, &Error{Kind: KindUnavailable, Message: "Error connecting to database"})
+	}
+
+	tx, err := begin(ctx, pool, s.timeout)
+	if err != nil {
+		return LookupResult{}, queryError(ctx, err)
+	}
+	defer tx.Rollback(context.Background())
+
+	// Construct the query
+	query := createLookupQuery(s.dataset.Database)
+
+	// Execute the query
+	row := tx.QueryRow(ctx, query, id)
+
+	// Parse the results
+	result, err := parseLookupResults(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return LookupResult{}, NotFoundError("feature %d not found", id)
+	}
+	if err != nil {
+		return LookupResult{}, queryError(ctx, err)
+	}
+
+	return result, nil
+}
+
+// Reverse finds the features closest to the point with the KNN operator of PostGIS on the geometry index.
+func (s *PostgresStore) Reverse(ctx context.Context, options ReverseOptions) ([]ReverseResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	pool, err := database.GetDBPool(ctx, s.dataset.Name, s.dataset.Database)
+	if err != nil {
+		log.Errorf("Error getting database pool: %v", err)
+		return nil, queryError(ctx, &Error{Kind: KindUnavailable, Message: "Error connecting to database"})
+	}
+
+	tx, err := begin(ctx, pool, s.timeout)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer tx.Rollback(context.Background())
+
+	rows, err := tx.Query(ctx, createReverseQuery(options, s.dataset.Database), options.Point.Lon, options.Point.Lat)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var results []ReverseResult
+	for rows.Next() {
+		var result ReverseResult
+		var geom sql.NullString
+		if err := rows.Scan(&result.ID, &result.Name, &result.Class, &result.Subclass, &result.Divisions, &result.DistanceKm, &geom); err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		if options.IncludeGeometry {
+			result.Geom = json.RawMessage(geom.String)
+		}
+		results = append(results, result)
+	}
+
+	return results, queryError(ctx, rows.Err())
+}
+
+// Stats counts the features per class and the aliases.
+func (s *PostgresStore) Stats(ctx context.Context) (Stats, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	stats := Stats{Classes: make(map[string]int64)}
+	pool, err := database.GetDBPool(ctx, s.dataset.Name, s.dataset.Database)
+	if err != nil {
+		return stats, fmt.Errorf("error getting database pool: %v", err)
+	}
+
+	tx, err := begin(ctx, pool, s.timeout)
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback(context.Background())
+
+	rows, err := tx.Query(ctx, fmt.Sprintf("SELECT class, count(*) FROM %s GROUP BY class;", database.OvertureTable(s.dataset.Database)))
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var class string
+		var count int64
+		if err := rows.Scan(&class, &count); err != nil {
+			return stats, err
+		}
+		stats.Classes[class] = count
+		stats.Features += count
+	}
+
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	err = tx.QueryRow(ctx, fmt.Sprintf("SELECT count(*) FROM %s;", database.SearchTable(s.dataset.Database))).Scan(&stats.Aliases)
+	return stats, err
+}
+
+func createReverseQuery(options ReverseOptions, db settings.DatabaseConfig) string {
+	geometryColumn := "NULL AS geom"
+	if options.IncludeGeometry {
+		geometryColumn = "ST_AsGeoJSON(o.geom) AS geom"
+	}
+
+	return fmt.Sprintf(`
+		WITH point AS (
+			SELECT ST_SetSRID(ST_MakePoint($1, $2), 4326) AS geom
+		)
+		SELECT
+			o.id,
+			o.name,
+			o.class,
+			o.subclass,
+			array_to_string(o.divisions, ',') AS divisions,
+			ST_Distance(o.geom::geography, point.geom::geography) / 1000 AS distance,
+			%[2]s
+		FROM
+			%[1]s AS o, point
+		WHERE
+			o.class IN %[3]s
+		ORDER BY
+			o.geom <-> point.geom
+		LIMIT %[4]d;`,
+		database.OvertureTable(db), geometryColumn, GeocodeOptions{Classes: options.Classes}.ClassesToSqlArray(), options.Limit)
+}
+
+// Words returns the words of the tsvectors in the search table.
+func (s *PostgresStore) Words(ctx context.Context) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, vocabularyTimeout)
+	defer cancel()
+
+	pool, err := database.GetDBPool(ctx, s.dataset.Name, s.dataset.Database)
+	if err != nil {
+		return nil, fmt.Errorf("error getting database pool: %v", err)
+	}
+
+	tx, err := begin(ctx, pool, vocabularyTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	rows, err := tx.Query(ctx, fmt.Sprintf("SELECT word, ndoc FROM ts_stat('SELECT vector_search FROM %s');", database.SearchTable(s.dataset.Database)))
+	if err != nil {
+		return nil, fmt.Errorf("error querying vocabulary: %v", err)
+	}
+	defer rows.Close()
+
+	words := make(map[string]int)
+	for rows.Next() {
+		var word string
+		var count int
+		if err := rows.Scan(&word, &count); err != nil {
+			return nil, fmt.Errorf("error reading vocabulary: %v", err)
+		}
+		words[word] = count
+	}
+
+	return words, rows.Err()
+}
+
+// begin starts a read only transaction with the statement timeout set in the database, a query that
+// keeps running after the deadline of the request is cancelled by the database and frees its connection.
+// The transaction is rolled back when the queries are done.
+func begin(ctx context.Context, pool *pgxpool.Pool, timeout time.Duration) (pgx.Tx, error) {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d;", timeout.Milliseconds()))
+	if err != nil {
+		tx.Rollback(context.Background())
+		return nil, err
+	}
+
+	return tx, nil
+}
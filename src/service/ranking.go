@@ -0,0 +1,88 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tebben/geocodeur/settings"
+)
+
+// Point is a WGS84 location used to rank results by distance.
+type Point struct {
+	Lon float64 `json:"lon"`
+	Lat float64 `json:"lat"`
+}
+
+// ParsePoint parses a point from a "lon,lat" string.
+func ParsePoint(s string) (*Point, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return nil, InvalidInputError("point %s should be formatted as lon,lat", s)
+	}
+
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return nil, InvalidInputError("invalid longitude in point %s", s)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return nil, InvalidInputError("invalid latitude in point %s", s)
+	}
+
+	return &Point{Lon: lon, Lat: lat}, nil
+}
+
+// RankingModel determines the order of the geocode results.
+type RankingModel interface {
+	// ScoreExpression returns the SQL expression calculating the score of a result,
+	// results are ordered by the score descending. The expression can use the columns
+	// sim, class_rank, subclass_rank and importance of the search result "a" and the feature "b".
+	ScoreExpression(focus *Point) string
+	// Score calculates the score of a result like ScoreExpression does, it's used by the
+	// stores that rank in Go. The distance to the focus point is 0 without focus point.
+	Score(sim float64, classRank int, subclassRank int, importance float64, distanceKm float64) float64
+}
+
+// WeightedRanking scores results with a weighted sum of similarity, class rank,
+// subclass rank, importance and distance to the focus point.
+type WeightedRanking struct {
+	Weights settings.RankingWeights
+}
+
+// NewRankingModel creates the ranking model from the ranking configuration.
+func NewRankingModel(config settings.RankingConfig) RankingModel {
+	return WeightedRanking{Weights: config.Weights}
+}
+
+func (w WeightedRanking) ScoreExpression(focus *Point) string {
+	terms := []string{fmt.Sprintf("%v * a.sim", w.Weights.Similarity)}
+
+	if w.Weights.Class != 0 {
+		terms = append(terms, fmt.Sprintf("%v * a.class_rank", -w.Weights.Class))
+	}
+
+	if w.Weights.Subclass != 0 {
+		terms = append(terms, fmt.Sprintf("%v * a.subclass_rank", -w.Weights.Subclass))
+	}
+
+	if w.Weights.Importance != 0 {
+		terms = append(terms, fmt.Sprintf("%v * a.importance", w.Weights.Importance))
+	}
+
+	// Distance to the focus point in kilometers
+	if w.Weights.Distance != 0 && focus != nil {
+		terms = append(terms, fmt.Sprintf("%v * ST_Distance(b.geom::geography, ST_SetSRID(ST_MakePoint(%v, %v), 4326)::geography) / 1000", -w.Weights.Distance, focus.Lon, focus.Lat))
+	}
+
+	return fmt.Sprintf("(%s)", strings.Join(terms, " + "))
+}
+
+func (w WeightedRanking) Score(sim float64, classRank int, subclassRank int, importance float64, distanceKm float64) float64 {
+	return w.Weights.Similarity*sim -
+		w.Weights.Class*float64(classRank) -
+		w.Weights.Subclass*float64(subclassRank) +
+		w.Weights.Importance*importance -
+		w.Weights.Distance*distanceKm
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/tebben/geocodeur/geometry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// kmPerDegree is the length of a degree latitude in kilometers.
+const kmPerDegree = 111.19
+
+type ReverseResult struct {
+	ID         uint64          `json:"id" doc:"The id of the feature, not the original Overture id"`
+	Name       string          `json:"name" doc:"The name of the feature"`
+	Class      string          `json:"class" doc:"The class of the feature"`
+	Subclass   string          `json:"subclass" doc:"The subclass of the feature"`
+	Divisions  string          `json:"divisions" doc:"The divisions of the feature"`
+	DistanceKm float64         `json:"distanceKm" doc:"Distance in kilometers from the point to the feature"`
+	Geom       json.RawMessage `json:"geom,omitempty" doc:"The geometry of the feature in GeoJSON format"`
+}
+
+type ReverseOptions struct {
+	Point           Point
+	Limit           uint16
+	Classes         []Class
+	IncludeGeometry bool
+}
+
+// Stats are the number of features and aliases in a dataset.
+type Stats struct {
+	Features int64            `json:"features"`
+	Aliases  int64            `json:"aliases"`
+	Classes  map[string]int64 `json:"classes"`
+}
+
+// NewReverseOptions creates the options to find the features closest to a point.
+func NewReverseOptions(point Point, limit uint16, classes []Class, includeGeom bool) ReverseOptions {
+	return ReverseOptions{
+		Point:           point,
+		Limit:           limit,
+		Classes:         classes,
+		IncludeGeometry: includeGeom,
+	}
+}
+
+// Reverse returns the features of a dataset closest to the point, closest first.
+func Reverse(ctx context.Context, dataset *Dataset, options ReverseOptions) (results []ReverseResult, err error) {
+	ctx, span := startSpan(ctx, "reverse", attribute.String("geocodeur.dataset", dataset.Name), attribute.Int("geocodeur.limit", int(options.Limit)))
+	defer func() { endSpan(span, err) }()
+
+	options.Classes, err = datasetClasses(dataset.DatasetConfig, options.Classes)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataset.Store.Reverse(ctx, options)
+}
+
+// DatasetStats returns the number of features per class and the number of aliases of a dataset.
+func DatasetStats(ctx context.Context, dataset *Dataset) (Stats, error) {
+	return dataset.Store.Stats(ctx)
+}
+
+// classes returns the classes to search on, all classes when no classes are set.
+func (r ReverseOptions) classes() []Class {
+	return GeocodeOptions{Classes: r.Classes}.classes()
+}
+
+// reverseFeatures finds the features closest to the point for the stores without PostGIS. Features are
+// searched in a box around the point that grows until enough features are found that are closer than the
+// edge of the box, distances are calculated to the bounds of the geometries.
+func reverseFeatures(ctx context.Context, source candidateSource, options ReverseOptions) ([]ReverseResult, error) {
+	classes := make(map[string]bool)
+	for _, class := range options.classes() {
+		classes[string(class)] = true
+	}
+
+	lon, lat := options.Point.Lon, options.Point.Lat
+	limit := max(int(options.Limit), 1)
+	for size := 0.005; ; size *= 4 {
+		box := geometry.Bounds{MinLon: lon - size, MinLat: lat - size, MaxLon: lon + size, MaxLat: lat + size}
+		if err := ctx.Err(); err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		features, err := source.featuresWithin(ctx, box)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		var results []ReverseResult
+		for _, feature := range features {
+			if !classes[feature.Class] {
+				continue
+			}
+
+			results = append(results, ReverseResult{
+				ID:         feature.ID,
+				Name:       feature.Name,
+				Class:      feature.Class,
+				Subclass:   feature.Subclass,
+				Divisions:  strings.Join(feature.Divisions, ","),
+				DistanceKm: feature.Bounds.DistanceKm(lon, lat),
+			})
+		}
+
+		sort.Slice(results, func(i, j int) bool {
+			if results[i].DistanceKm != results[j].DistanceKm {
+				return results[i].DistanceKm < results[j].DistanceKm
+			}
+			return results[i].ID < results[j].ID
+		})
+
+		// Features closer than the edge of the box are always inside it
+		edgeKm := size * kmPerDegree * math.Cos(lat*math.Pi/180)
+		done := len(results) >= limit && results[limit-1].DistanceKm <= edgeKm
+		if !done && size < 180 {
+			continue
+		}
+
+		if len(results) > limit {
+			results = results[:limit]
+		}
+
+		if options.IncludeGeometry {
+			if err := addGeometries(ctx, source, results); err != nil {
+				return nil, err
+			}
+		}
+
+		return results, nil
+	}
+}
+
+func addGeometries(ctx context.Context, source candidateSource, results []ReverseResult) error {
+	ids := make([]uint64, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+
+	features, err := source.features(ctx, ids, true)
+	if err != nil {
+		return err
+	}
+
+	for i := range results {
+		results[i].Geom = features[results[i].ID].Geom
+	}
+
+	return nil
+}
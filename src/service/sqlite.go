@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tebben/geocodeur/database"
+	"github.com/tebben/geocodeur/geometry"
+	"github.com/tebben/geocodeur/settings"
+	"github.com/tebben/geocodeur/text"
+	_ "modernc.org/sqlite"
+)
+
+// trgmCandidateLimit is the maximum number of aliases sharing trigrams with the input that are
+// compared, the aliases sharing the most trigrams are read first.
+const trgmCandidateLimit = 1000
+
+// SQLiteStore searches a SQLite file created with create --backend sqlite. Candidates are found with
+// FTS5 and matched and ranked in Go with the same semantics as the Postgres query, distances to the
+// focus point are calculated to the bounds of the geometries.
+type SQLiteStore struct {
+	db      *sql.DB
+	ranking settings.RankingConfig
+}
+
+// NewSQLiteStore opens a SQLite file read-only.
+func NewSQLiteStore(path string, ranking settings.RankingConfig) (*SQLiteStore, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+
+	return &SQLiteStore{db: db, ranking: ranking}, nil
+}
+
+func (s *SQLiteStore) Search(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]GeocodeResult, ExplainTiming, error) {
+	return searchCandidates(ctx, s, options, s.ranking, terms)
+}
+
+func (s *SQLiteStore) Lookup(ctx context.Context, id uint64) (LookupResult, error) {
+	features, err := s.features(ctx, []uint64{id}, true)
+	if err != nil {
+		return LookupResult{}, err
+	}
+
+	feature, ok := features[id]
+	if !ok {
+		return LookupResult{}, NotFoundError("feature %d not found", id)
+	}
+
+	return LookupResult{
+		ID:        feature.ID,
+		Name:      feature.Name,
+		Class:     feature.Class,
+		Subclass:  feature.Subclass,
+		Divisions: strings.Join(feature.Divisions, ","),
+		Geom:      feature.Geom,
+	}, nil
+}
+
+// Words counts the aliases every word occurs in.
+func (s *SQLiteStore) Words(ctx context.Context) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT alias FROM %s;", database.SQLiteSearchTable))
+	if err != nil {
+		return nil, fmt.Errorf("error querying vocabulary: %v", err)
+	}
+	defer rows.Close()
+
+	words := make(map[string]int)
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, fmt.Errorf("error reading vocabulary: %v", err)
+		}
+
+		seen := make(map[string]bool)
+		for _, word := range text.Words(alias) {
+			if !seen[word] {
+				seen[word] = true
+				words[word]++
+			}
+		}
+	}
+
+	return words, rows.Err()
+}
+
+func (s *SQLiteStore) Reverse(ctx context.Context, options ReverseOptions) ([]ReverseResult, error) {
+	return reverseFeatures(ctx, s, options)
+}
+
+func (s *SQLiteStore) Stats(ctx context.Context) (Stats, error) {
+	stats := Stats{Classes: make(map[string]int64)}
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT class, count(*) FROM %s GROUP BY class;", database.SQLiteOvertureTable))
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var class string
+		var count int64
+		if err := rows.Scan(&class, &count); err != nil {
+			return stats, err
+		}
+		stats.Classes[class] = count
+		stats.Features += count
+	}
+
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s;", database.SQLiteSearchTable)).Scan(&stats.Aliases)
+	return stats, err
+}
+
+// ftsCandidates finds the aliases containing every token or one of its corrections. The trigram
+// tokenizer matches substrings of at least 3 characters, shorter tokens are matched with LIKE.
+// Substrings in the middle of a word are dropped while reading, the candidateLimit is applied by
+// the caller after matching like the LIMIT of the Postgres query.
+func (s *SQLiteStore) ftsCandidates(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]Candidate, error) {
+	var groups []string
+	var where []string
+	var args []any
+	for _, token := range terms.tokens {
+		var parts []string
+		for _, part := range text.Words(token.lexeme) {
+			if utf8.RuneCountInString(part) < 3 {
+				where = append(where, `s.alias LIKE ? ESCAPE '\'`)
+				args = append(args, "%"+likeString(part)+"%")
+				continue
+			}
+			parts = append(parts, ftsString(part))
+		}
+
+		if len(parts) == 0 {
+			continue
+		}
+
+		options := []string{strings.Join(parts, " AND ")}
+		for _, correction := range token.corrections {
+			if utf8.RuneCountInString(correction) >= 3 {
+				options = append(options, ftsString(correction))
+			}
+		}
+		groups = append(groups, fmt.Sprintf("(%s)", strings.Join(options, " OR ")))
+	}
+
+	if len(groups) == 0 && len(where) == 0 {
+		return nil, nil
+	}
+
+	from := fmt.Sprintf("%s AS s", database.SQLiteSearchTable)
+	if len(groups) > 0 {
+		from = fmt.Sprintf("%[1]s AS f JOIN %[2]s AS s ON s.rowid = f.rowid", database.SQLiteFTSTable, database.SQLiteSearchTable)
+		where = append([]string{fmt.Sprintf("%s MATCH ?", database.SQLiteFTSTable)}, where...)
+		args = append([]any{strings.Join(groups, " AND ")}, args...)
+	}
+
+	return s.candidates(ctx, from, where, args, "", 0, options, terms, terms.matches)
+}
+
+// trgmCandidates finds the aliases sharing the most trigrams with the corrected input.
+func (s *SQLiteStore) trgmCandidates(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]Candidate, error) {
+	seen := make(map[string]bool)
+	var trigrams []string
+	for _, word := range text.Words(terms.Corrected) {
+		runes := []rune(word)
+		for i := 0; i+3 <= len(runes); i++ {
+			trigram := string(runes[i : i+3])
+			if !seen[trigram] {
+				seen[trigram] = true
+				trigrams = append(trigrams, ftsString(trigram))
+			}
+		}
+	}
+
+	if len(trigrams) == 0 {
+		return nil, nil
+	}
+
+	from := fmt.Sprintf("%[1]s AS f JOIN %[2]s AS s ON s.rowid = f.rowid", database.SQLiteFTSTable, database.SQLiteSearchTable)
+	where := []string{fmt.Sprintf("%s MATCH ?", database.SQLiteFTSTable)}
+	args := []any{strings.Join(trigrams, " OR ")}
+
+	return s.candidates(ctx, from, where, args, "ORDER BY f.rank", trgmCandidateLimit, options, terms, nil)
+}
+
+// candidates reads the aliases of the from clause matching the conditions in the order of the order clause,
+// at most limit aliases are read when limit is set. Only the aliases accepted by keep are returned, all
+// aliases when keep is nil.
+func (s *SQLiteStore) candidates(ctx context.Context, from string, where []string, args []any, order string, limit int, options GeocodeOptions, terms SearchTerms, keep func(alias string) bool) ([]Candidate, error) {
+	// Same prefilter on the number of words, characters and the class of the feature as the Postgres query
+	where = append(where, "abs(s.word_count - ?) < 3", "abs(s.char_count - ?) < 30", fmt.Sprintf("o.class IN %s", options.ClassesToSqlArray()))
+	args = append(args, terms.wordCount(), terms.charCount())
+
+	if limit > 0 {
+		order += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT s.feature_id, o.class, s.alias, s.class_rank, s.subclass_rank, s.importance, s.word_count, s.char_count
+		FROM %[1]s
+		JOIN %[2]s AS o ON o.id = s.feature_id
+		WHERE %[3]s
+		%[4]s;
+	`, from, database.SQLiteOvertureTable, strings.Join(where, " AND "), order)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []Candidate
+	for rows.Next() {
+		var c Candidate
+		if err := rows.Scan(&c.FeatureID, &c.Class, &c.Alias, &c.ClassRank, &c.SubclassRank, &c.Importance, &c.WordCount, &c.CharCount); err != nil {
+			return nil, err
+		}
+
+		if keep == nil || keep(c.Alias) {
+			candidates = append(candidates, c)
+		}
+	}
+
+	return candidates, rows.Err()
+}
+
+func (s *SQLiteStore) features(ctx context.Context, ids []uint64, includeGeometry bool) (map[uint64]Feature, error) {
+	features := make(map[uint64]Feature, len(ids))
+	if len(ids) == 0 {
+		return features, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = int64(id)
+	}
+
+	list, err := s.queryFeatures(ctx, fmt.Sprintf("o.id IN (%s)", strings.Join(placeholders, ", ")), args, includeGeometry)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range list {
+		features[f.ID] = f
+	}
+
+	return features, nil
+}
+
+func (s *SQLiteStore) featuresWithin(ctx context.Context, box geometry.Bounds) ([]Feature, error) {
+	where := "r.max_lon >= ? AND r.min_lon <= ? AND r.max_lat >= ? AND r.min_lat <= ?"
+	return s.queryFeatures(ctx, where, []any{box.MinLon, box.MaxLon, box.MinLat, box.MaxLat}, false)
+}
+
+func (s *SQLiteStore) queryFeatures(ctx context.Context, where string, args []any, includeGeometry bool) ([]Feature, error) {
+	geometryColumn := "''"
+	if includeGeometry {
+		geometryColumn = "o.geom"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT o.id, o.name, o.class, o.subclass, o.divisions, %[1]s, r.min_lon, r.min_lat, r.max_lon, r.max_lat
+		FROM %[2]s AS o
+		JOIN %[3]s AS r ON r.id = o.id
+		WHERE %[4]s;
+	`, geometryColumn, database.SQLiteOvertureTable, database.SQLiteRTreeTable, where)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var features []Feature
+	for rows.Next() {
+		var f Feature
+		var divisions, geom string
+		if err := rows.Scan(&f.ID, &f.Name, &f.Class, &f.Subclass, &divisions, &geom, &f.Bounds.MinLon, &f.Bounds.MinLat, &f.Bounds.MaxLon, &f.Bounds.MaxLat); err != nil {
+			return nil, err
+		}
+
+		f.Divisions = database.SQLiteDivisions(divisions)
+		if geom != "" {
+			f.Geom = []byte(geom)
+		}
+		features = append(features, f)
+	}
+
+	return features, rows.Err()
+}
+
+// likeString escapes the wildcards of a LIKE pattern, the pattern has to be used with ESCAPE '\'.
+func likeString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// ftsString quotes a string for an FTS5 query.
+func ftsString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
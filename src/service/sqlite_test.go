@@ -0,0 +1,39 @@
+package service
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestLikeString(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		alias   string
+		part    string
+		matches bool
+	}{
+		{"a%b", "%", true},
+		{"ab", "%", false},
+		{"a_b", "_", true},
+		{"ab", "_", false},
+		{`a\b`, `\`, true},
+		{"kerkstraat", "st", true},
+	}
+
+	for _, tt := range tests {
+		var matches bool
+		err := db.QueryRow(`SELECT ? LIKE ? ESCAPE '\'`, tt.alias, "%"+likeString(tt.part)+"%").Scan(&matches)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if matches != tt.matches {
+			t.Errorf("%q LIKE %q = %v, want %v", tt.alias, tt.part, matches, tt.matches)
+		}
+	}
+}
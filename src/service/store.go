@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/tebben/geocodeur/settings"
+)
+
+// Store searches and looks up the features of a dataset, there is a store for every storage backend.
+type Store interface {
+	// Search returns the results matching the search terms ordered by the ranking model of the options.
+	Search(ctx context.Context, options GeocodeOptions, terms SearchTerms) ([]GeocodeResult, ExplainTiming, error)
+	// Lookup returns the feature with the given id.
+	Lookup(ctx context.Context, id uint64) (LookupResult, error)
+	// Words returns the words used in the aliases with the number of aliases they occur in.
+	Words(ctx context.Context) (map[string]int, error)
+	// Reverse returns the features closest to the point of the options, closest first.
+	Reverse(ctx context.Context, options ReverseOptions) ([]ReverseResult, error)
+	// Stats returns the number of features per class and the number of aliases.
+	Stats(ctx context.Context) (Stats, error)
+}
+
+// OpenStore opens the store of a dataset for its configured backend.
+func OpenStore(dataset settings.DatasetConfig, config settings.Config) (Store, error) {
+	switch dataset.Backend {
+	case settings.BackendPostgres:
+		return NewPostgresStore(dataset, config.Ranking, config.API), nil
+	case settings.BackendSQLite:
+		return NewSQLiteStore(dataset.File, config.Ranking)
+	case settings.BackendIndex:
+		return NewIndexStore(dataset.File, config.Ranking)
+	default:
+		return nil, fmt.Errorf("unknown backend '%s' for dataset %s", dataset.Backend, dataset.Name)
+	}
+}
+
+// Dataset is a dataset served by the API with the store it's searched in.
+type Dataset struct {
+	settings.DatasetConfig
+	Store   Store
+	Ranking settings.RankingConfig
+}
+
+// Datasets are the datasets served by the API, the first dataset is used when no dataset is requested.
+type Datasets struct {
+	datasets []*Dataset
+}
+
+// NewDatasets creates the datasets to serve from datasets with an open store.
+func NewDatasets(datasets ...*Dataset) *Datasets {
+	return &Datasets{datasets: datasets}
+}
+
+// OpenDatasets opens the stores of all configured datasets.
+func OpenDatasets(config settings.Config) (*Datasets, error) {
+	datasets := &Datasets{}
+	for _, dataset := range config.Datasets {
+		store, err := OpenStore(dataset, config)
+		if err != nil {
+			return nil, fmt.Errorf("error opening dataset %s: %v", dataset.Name, err)
+		}
+
+		datasets.datasets = append(datasets.datasets, &Dataset{DatasetConfig: dataset, Store: store, Ranking: config.Ranking})
+	}
+
+	return datasets, nil
+}
+
+// Reconfigure returns the datasets with the ranking weights and statement timeout of a new config, the
+// open stores are kept. Changes to the datasets themselves, like another backend or database, need a
+// restart and are returned as warnings. Class and subclass ranks are kept since they are stored with the data.
+func (d *Datasets) Reconfigure(config settings.Config) (*Datasets, []string) {
+	var warnings []string
+	reconfigured := &Datasets{}
+	for _, dataset := range d.datasets {
+		configured, err := config.Dataset(dataset.Name)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("dataset %s is no longer configured, it's served until a restart", dataset.Name))
+		} else if !reflect.DeepEqual(configured, dataset.DatasetConfig) {
+			warnings = append(warnings, fmt.Sprintf("dataset %s has changed, the changes are applied after a restart", dataset.Name))
+		}
+
+		ranking := dataset.Ranking
+		ranking.Weights = config.Ranking.Weights
+
+		store := dataset.Store
+		if _, ok := store.(*PostgresStore); ok {
+			store = NewPostgresStore(dataset.DatasetConfig, ranking, config.API)
+		}
+
+		reconfigured.datasets = append(reconfigured.datasets, &Dataset{DatasetConfig: dataset.DatasetConfig, Store: store, Ranking: ranking})
+	}
+
+	for _, configured := range config.Datasets {
+		if _, err := d.Get(configured.Name); err != nil {
+			warnings = append(warnings, fmt.Sprintf("dataset %s is served after a restart", configured.Name))
+		}
+	}
+
+	return reconfigured, warnings
+}
+
+// Get returns the dataset with the given name, the first dataset when the name is empty.
+func (d *Datasets) Get(name string) (*Dataset, error) {
+	if name == "" && len(d.datasets) > 0 {
+		return d.datasets[0], nil
+	}
+
+	for _, dataset := range d.datasets {
+		if dataset.Name == name {
+			return dataset, nil
+		}
+	}
+
+	return nil, NotFoundError("dataset '%s' not found", name)
+}
+
+// All returns all datasets in the configured order.
+func (d *Datasets) All() []*Dataset {
+	return d.datasets
+}
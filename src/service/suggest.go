@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tebben/geocodeur/text"
+)
+
+var (
+	vocabularies    = make(map[string]*Vocabulary)
+	vocabularyMutex sync.RWMutex
+)
+
+// Vocabulary contains all words used in the aliases with their frequency,
+// it's used to suggest corrected queries when a search has no results.
+type Vocabulary struct {
+	words    []string
+	counts   []int
+	lengths  []int
+	index    map[string]int
+	sorted   []string
+	trigrams map[string][]int
+}
+
+// NewVocabulary creates a vocabulary from a map of words and their frequency.
+func NewVocabulary(words map[string]int) *Vocabulary {
+	v := &Vocabulary{
+		words:    make([]string, 0, len(words)),
+		counts:   make([]int, 0, len(words)),
+		lengths:  make([]int, 0, len(words)),
+		index:    make(map[string]int, len(words)),
+		trigrams: make(map[string][]int),
+	}
+
+	for word, count := range words {
+		if len(word) < 2 {
+			continue
+		}
+
+		id := len(v.words)
+		v.words = append(v.words, word)
+		v.counts = append(v.counts, count)
+		v.lengths = append(v.lengths, utf8.RuneCountInString(word))
+		v.index[word] = id
+
+		for _, trigram := range innerTrigrams(word) {
+			v.trigrams[trigram] = append(v.trigrams[trigram], id)
+		}
+	}
+
+	v.sorted = append([]string{}, v.words...)
+	sort.Strings(v.sorted)
+
+	return v
+}
+
+// Size returns the number of words in the vocabulary.
+func (v *Vocabulary) Size() int {
+	return len(v.words)
+}
+
+// Suggest returns up to max corrected queries for the input, a token that is not
+// in the vocabulary is replaced by the closest words based on trigrams and edit distance.
+// No suggestions are returned when every token is known or no correction is found.
+func (v *Vocabulary) Suggest(input string, max int) []string {
+	if v == nil {
+		return nil
+	}
+
+	tokens := strings.Fields(strings.ToLower(input))
+	if len(tokens) == 0 || max <= 0 {
+		return nil
+	}
+
+	candidates := make([][]string, len(tokens))
+	corrected := false
+	for i, token := range tokens {
+		if v.isKnown(token) {
+			candidates[i] = []string{token}
+			continue
+		}
+
+		candidates[i] = v.corrections(token, max)
+		if len(candidates[i]) == 0 {
+			candidates[i] = []string{token}
+			continue
+		}
+
+		corrected = true
+	}
+
+	if !corrected {
+		return nil
+	}
+
+	// The first suggestion uses the best correction for every token, the next
+	// suggestions swap a single token for one of its other corrections.
+	best := make([]string, len(tokens))
+	for i, c := range candidates {
+		best[i] = c[0]
+	}
+
+	suggestions := []string{strings.Join(best, " ")}
+	for i, c := range candidates {
+		for _, alternative := range c[1:] {
+			if len(suggestions) >= max {
+				return suggestions
+			}
+
+			tokens := append([]string{}, best...)
+			tokens[i] = alternative
+			suggestions = append(suggestions, strings.Join(tokens, " "))
+		}
+	}
+
+	return suggestions
+}
+
+// isKnown checks if a token is a word or the prefix of a word in the vocabulary,
+// like FTS does with prefix matching. Tokens of 1 or 2 runes are known without
+// checking the vocabulary: they are house numbers, house number suffixes and
+// abbreviations like "st", and within an edit distance of 1 they would be
+// corrected to almost any short word.
+func (v *Vocabulary) isKnown(token string) bool {
+	if len([]rune(token)) < 3 {
+		return true
+	}
+
+	if _, ok := v.index[token]; ok {
+		return true
+	}
+
+	i := sort.SearchStrings(v.sorted, token)
+	return i < len(v.sorted) && strings.HasPrefix(v.sorted[i], token)
+}
+
+// corrections returns up to max words closest to the token, ordered by
+// edit distance and frequency. Only words sharing at least half of the trigrams
+// of the token and with a length within the edit distance are compared.
+func (v *Vocabulary) corrections(token string, max int) []string {
+	length := utf8.RuneCountInString(token)
+	maxDistance := 1
+	if length > 4 {
+		maxDistance = 2
+	}
+
+	trigrams := innerTrigrams(token)
+	minShared := len(trigrams) / 2
+	if minShared < 1 {
+		minShared = 1
+	}
+
+	shared := make(map[int]int)
+	for _, trigram := range trigrams {
+		for _, id := range v.trigrams[trigram] {
+			shared[id]++
+		}
+	}
+
+	type candidate struct {
+		id       int
+		distance int
+	}
+
+	var found []candidate
+	for id, count := range shared {
+		if count < minShared || abs(v.lengths[id]-length) > maxDistance {
+			continue
+		}
+
+		distance := text.EditDistance(token, v.words[id])
+		if distance <= maxDistance {
+			found = append(found, candidate{id, distance})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].distance != found[j].distance {
+			return found[i].distance < found[j].distance
+		}
+		if v.counts[found[i].id] != v.counts[found[j].id] {
+			return v.counts[found[i].id] > v.counts[found[j].id]
+		}
+		return v.words[found[i].id] < v.words[found[j].id]
+	})
+
+	corrections := make([]string, 0, max)
+	for _, c := range found {
+		if len(corrections) >= max {
+			break
+		}
+		corrections = append(corrections, v.words[c.id])
+	}
+
+	return corrections
+}
+
+// innerTrigrams returns the distinct trigrams of a word without the padded trigrams at the
+// start and end of the word, those are shared by every word with the same first or last letter.
+func innerTrigrams(word string) []string {
+	seen := make(map[string]bool)
+	var trigrams []string
+	for _, trigram := range text.Trigrams(word) {
+		if strings.Contains(trigram, " ") || seen[trigram] {
+			continue
+		}
+		seen[trigram] = true
+		trigrams = append(trigrams, trigram)
+	}
+
+	return trigrams
+}
+
+// LoadVocabulary builds the vocabulary of a dataset from the words in its aliases.
+func LoadVocabulary(dataset *Dataset) error {
+	timeStart := time.Now()
+	words, err := dataset.Store.Words(context.Background())
+	if err != nil {
+		return err
+	}
+
+	SetVocabulary(dataset.Name, NewVocabulary(words))
+	log.Infof("Loaded vocabulary of %d words for dataset %s in %v", len(words), dataset.Name, time.Since(timeStart))
+
+	return nil
+}
+
+// TokenCorrections returns up to max corrections for a token that is not known in the
+// vocabulary, nil is returned for known tokens or when no vocabulary is loaded.
+func (v *Vocabulary) TokenCorrections(token string, max int) []string {
+	if v == nil || v.isKnown(token) {
+		return nil
+	}
+
+	return v.corrections(token, max)
+}
+
+// GetVocabulary returns the vocabulary of a dataset, nil when no vocabulary is loaded.
+func GetVocabulary(dataset string) *Vocabulary {
+	vocabularyMutex.RLock()
+	defer vocabularyMutex.RUnlock()
+
+	return vocabularies[dataset]
+}
+
+// SetVocabulary sets the vocabulary of a dataset used for suggestions and fuzzy matching.
+func SetVocabulary(dataset string, v *Vocabulary) {
+	vocabularyMutex.Lock()
+	defer vocabularyMutex.Unlock()
+	vocabularies[dataset] = v
+}
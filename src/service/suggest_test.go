@@ -0,0 +1,83 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testVocabulary() *Vocabulary {
+	return NewVocabulary(map[string]int{
+		"kerkstraat": 40,
+		"kerkplein":  10,
+		"vught":      5,
+		"utrecht":    20,
+		"amsterdam":  30,
+		"den":        15,
+		"st":         3,
+	})
+}
+
+func TestSuggest(t *testing.T) {
+	vocabulary := testVocabulary()
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"misspelled tokens", "kerkstrat vugt", []string{"kerkstraat vught"}},
+		{"misspelled token with known token", "Kerkstraat vugt", []string{"kerkstraat vught"}},
+		{"known tokens", "kerkstraat vught", nil},
+		{"known prefixes", "kerk ut", nil},
+		{"short tokens are known", "kerkstrat 1 b", []string{"kerkstraat 1 b"}},
+		{"short tokens only", "xq 12", nil},
+		{"no correction", "zzzzzz", nil},
+		{"empty input", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := vocabulary.Suggest(tt.input, 3)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Suggest(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggestNilVocabulary(t *testing.T) {
+	var vocabulary *Vocabulary
+	if got := vocabulary.Suggest("kerkstrat", 3); got != nil {
+		t.Errorf("Suggest on nil vocabulary = %q, want nil", got)
+	}
+}
+
+func TestTokenCorrections(t *testing.T) {
+	vocabulary := testVocabulary()
+
+	tests := []struct {
+		token string
+		want  []string
+	}{
+		{"kerkstrat", []string{"kerkstraat"}},
+		{"vugt", []string{"vught"}},
+		{"utrect", []string{"utrecht"}},
+		{"kerkstraat", nil},
+		{"kerkpl", nil},
+		{"a", nil},
+		{"xq", nil},
+		{"12", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			got := vocabulary.TokenCorrections(tt.token, 3)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TokenCorrections(%q) = %q, want %q", tt.token, got, tt.want)
+			}
+		})
+	}
+}
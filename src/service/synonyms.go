@@ -0,0 +1,110 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tebben/geocodeur/settings"
+)
+
+var (
+	synonyms      *Synonyms
+	synonymsMutex sync.RWMutex
+)
+
+// Synonyms rewrites the configured aliases of names in a query to the name, so an alias added to the
+// config also finds the features loaded before it was added: "kerkstraat den bosch" is searched as
+// "kerkstraat 's-hertogenbosch".
+type Synonyms struct {
+	replacements []synonym
+}
+
+type synonym struct {
+	alias []string
+	name  []string
+}
+
+// NewSynonyms creates the synonyms of the configured names and aliases.
+func NewSynonyms(config settings.AliasConfig) *Synonyms {
+	s := &Synonyms{}
+	for name, alias := range config.Names {
+		s.replacements = append(s.replacements, synonym{
+			alias: strings.Fields(strings.ToLower(alias)),
+			name:  strings.Fields(strings.ToLower(name)),
+		})
+	}
+
+	// Longest aliases first so "den bosch" is replaced before "bosch"
+	sort.Slice(s.replacements, func(i, j int) bool {
+		a, b := s.replacements[i], s.replacements[j]
+		if len(a.alias) != len(b.alias) {
+			return len(a.alias) > len(b.alias)
+		}
+		return strings.Join(a.alias, " ") < strings.Join(b.alias, " ")
+	})
+
+	return s
+}
+
+// Expand returns the lowercased input with every alias replaced by its name, aliases are only
+// replaced when all their words are in the input.
+func (s *Synonyms) Expand(input string) string {
+	tokens := strings.Fields(strings.ToLower(input))
+	if s == nil || len(s.replacements) == 0 {
+		return strings.Join(tokens, " ")
+	}
+
+	expanded := make([]string, 0, len(tokens))
+	for i := 0; i < len(tokens); {
+		replacement, ok := s.match(tokens[i:])
+		if !ok {
+			expanded = append(expanded, tokens[i])
+			i++
+			continue
+		}
+
+		expanded = append(expanded, replacement.name...)
+		i += len(replacement.alias)
+	}
+
+	return strings.Join(expanded, " ")
+}
+
+// match returns the synonym of the alias the tokens start with.
+func (s *Synonyms) match(tokens []string) (synonym, bool) {
+	for _, replacement := range s.replacements {
+		if len(replacement.alias) == 0 || len(replacement.alias) > len(tokens) {
+			continue
+		}
+
+		matched := true
+		for i, word := range replacement.alias {
+			if tokens[i] != word {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return replacement, true
+		}
+	}
+
+	return synonym{}, false
+}
+
+// GetSynonyms returns the synonyms used for geocoding, nil when none are set.
+func GetSynonyms() *Synonyms {
+	synonymsMutex.RLock()
+	defer synonymsMutex.RUnlock()
+
+	return synonyms
+}
+
+// SetSynonyms sets the synonyms used for geocoding.
+func SetSynonyms(s *Synonyms) {
+	synonymsMutex.Lock()
+	defer synonymsMutex.Unlock()
+	synonyms = s
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/tebben/geocodeur/settings"
+)
+
+func TestSynonymsExpand(t *testing.T) {
+	synonyms := NewSynonyms(settings.AliasConfig{Names: map[string]string{"'s-Hertogenbosch": "Den Bosch"}})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"den bosch", "'s-hertogenbosch"},
+		{"Kerkstraat Den Bosch", "kerkstraat 's-hertogenbosch"},
+		{"den boschstraat", "den boschstraat"},
+		{"vught", "vught"},
+	}
+
+	for _, test := range tests {
+		if got := synonyms.Expand(test.input); got != test.expected {
+			t.Errorf("Expand(%q) = %q, expected %q", test.input, got, test.expected)
+		}
+	}
+
+	var none *Synonyms
+	if got := none.Expand("den bosch"); got != "den bosch" {
+		t.Errorf("Expand on nil synonyms = %q, expected the input", got)
+	}
+}
@@ -0,0 +1,75 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Diff describes the fields that differ between two configs as "key: old -> new", passwords in
+// connection strings are hidden.
+func Diff(old Config, updated Config) []string {
+	oldValues := flatten(old.Redacted())
+	newValues := flatten(updated.Redacted())
+
+	keys := make(map[string]bool)
+	for key := range oldValues {
+		keys[key] = true
+	}
+	for key := range newValues {
+		keys[key] = true
+	}
+
+	var changes []string
+	for key := range keys {
+		oldValue, inOld := oldValues[key]
+		newValue, inNew := newValues[key]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("%s: added %s", key, newValue))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("%s: removed %s", key, oldValue))
+		case oldValue != newValue:
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", key, oldValue, newValue))
+		}
+	}
+	sort.Strings(changes)
+
+	return changes
+}
+
+// flatten returns the JSON values of a config by the path of their keys, lists of strings
+// and numbers are kept as one value.
+func flatten(config Config) map[string]string {
+	data, _ := json.Marshal(config)
+	var values map[string]any
+	json.Unmarshal(data, &values)
+
+	flat := make(map[string]string)
+	flattenValue(flat, "", values)
+	return flat
+}
+
+func flattenValue(flat map[string]string, path string, value any) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, item := range value {
+			flattenValue(flat, joinPath(path, key), item)
+		}
+	case []any:
+		objects := false
+		for i, item := range value {
+			if _, ok := item.(map[string]any); ok {
+				objects = true
+				flattenValue(flat, fmt.Sprintf("%s[%d]", path, i), item)
+			}
+		}
+		if !objects {
+			data, _ := json.Marshal(value)
+			flat[path] = string(data)
+		}
+	default:
+		data, _ := json.Marshal(value)
+		flat[path] = string(data)
+	}
+}
@@ -0,0 +1,178 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// envPrefix is the prefix of the environment variables overriding config fields, the field is
+// named by the path of JSON keys joined with underscores: GEOCODEUR_DATABASE_CONNECTIONSTRING.
+const envPrefix = "GEOCODEUR_"
+
+var overrides []string
+
+// SetOverrides sets config fields to override after the config file is loaded, every override
+// is a path of JSON keys joined with dots and a value: database.maxConnections=10.
+func SetOverrides(values []string) {
+	overrides = values
+}
+
+// applyEnv sets the config fields named by GEOCODEUR_ environment variables. Variables not starting
+// with a config section, like GEOCODEUR_VERSION, can be meant for something else and are skipped
+// with a warning, invalid variables of a config section are problems.
+func applyEnv(config *Config) []string {
+	var problems []string
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, envPrefix) || name == "GEOCODEUR_CONFIG_PATH" {
+			continue
+		}
+
+		path := strings.Split(strings.ToLower(strings.TrimPrefix(name, envPrefix)), "_")
+		if _, ok := fieldByKey(reflect.TypeOf(*config), path[0]); !ok {
+			log.Warnf("Ignoring environment variable %s, %s", name, unknownKey(reflect.TypeOf(*config), "", path[0]))
+			continue
+		}
+
+		err := setField(reflect.ValueOf(config).Elem(), path, "_", value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("environment variable %s: %v", name, err))
+		}
+	}
+
+	return problems
+}
+
+// applyOverrides sets the config fields of the overrides set with SetOverrides.
+func applyOverrides(config *Config) []string {
+	var problems []string
+	for _, override := range overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			problems = append(problems, fmt.Sprintf("override %s should be formatted as key=value", override))
+			continue
+		}
+
+		err := setField(reflect.ValueOf(config).Elem(), strings.Split(key, "."), ".", value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("override %s: %v", key, err))
+		}
+	}
+
+	return problems
+}
+
+// setField sets the field at the path of JSON keys, slices are indexed by number and the rest of the
+// path joined with sep is the key of a map. Lists of strings are set with comma separated values.
+func setField(v reflect.Value, path []string, sep string, value string) error {
+	if len(path) == 0 {
+		return setValue(v, value)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		field, ok := fieldByKey(v.Type(), path[0])
+		if !ok {
+			return errors.New(unknownKey(v.Type(), "", path[0]))
+		}
+		return setField(v.FieldByIndex(field.Index), path[1:], sep, value)
+	case reflect.Slice:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= v.Len() {
+			return fmt.Errorf("no item %s in a list of %d", path[0], v.Len())
+		}
+		return setField(v.Index(i), path[1:], sep, value)
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		item := reflect.New(v.Type().Elem()).Elem()
+		if err := setValue(item, value); err != nil {
+			return err
+		}
+		v.SetMapIndex(reflect.ValueOf(strings.Join(path, sep)), item)
+		return nil
+	default:
+		return fmt.Errorf("'%s' is not a config section", path[0])
+	}
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %s", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %s", value)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %s", value)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("a list of sections can't be set, set the fields of an item instead")
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("a config section can't be set, set one of its fields instead")
+	}
+
+	return nil
+}
+
+var passwordParam = regexp.MustCompile(`(?i)(password\s*=\s*)('[^']*'|\S+)`)
+
+// Redacted returns a copy of the config with the passwords in the connection strings hidden.
+func (c Config) Redacted() Config {
+	c.Database.ConnectionString = redactConnectionString(c.Database.ConnectionString)
+
+	datasets := make([]DatasetConfig, len(c.Datasets))
+	copy(datasets, c.Datasets)
+	for i := range datasets {
+		datasets[i].Database.ConnectionString = redactConnectionString(datasets[i].Database.ConnectionString)
+	}
+	c.Datasets = datasets
+
+	return c
+}
+
+// redactConnectionString hides the password of a URL or keyword/value connection string.
+func redactConnectionString(connection string) string {
+	u, err := url.Parse(connection)
+	if err != nil || u.Scheme == "" {
+		return passwordParam.ReplaceAllString(connection, "${1}xxxxx")
+	}
+
+	query := u.Query()
+	if query.Has("password") {
+		query.Set("password", "xxxxx")
+		u.RawQuery = query.Encode()
+	}
+
+	return u.Redacted()
+}
@@ -0,0 +1,214 @@
+package settings
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// setTestOverrides sets the overrides of the flags until the test is done.
+func setTestOverrides(t *testing.T, values ...string) {
+	t.Helper()
+
+	SetOverrides(values)
+	t.Cleanup(func() {
+		SetOverrides(nil)
+	})
+}
+
+func TestPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		env      string
+		override string
+		port     int
+	}{
+		{"default", `{}`, "", "", 8080},
+		{"file", `{"server": {"port": 8081}}`, "", "", 8081},
+		{"env over file", `{"server": {"port": 8081}}`, "8082", "", 8082},
+		{"flag over env", `{"server": {"port": 8081}}`, "8082", "server.port=8083", 8083},
+		{"flag over file", `{"server": {"port": 8081}}`, "", "server.port=8083", 8083},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("GEOCODEUR_SERVER_PORT", tt.env)
+			}
+			if tt.override != "" {
+				setTestOverrides(t, tt.override)
+			}
+
+			config, err := loadTestConfig(t, "geocodeur.conf", tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.Server.Port != tt.port {
+				t.Errorf("port = %d, want %d", config.Server.Port, tt.port)
+			}
+		})
+	}
+}
+
+func TestOverridePaths(t *testing.T) {
+	t.Setenv("GEOCODEUR_RANKING_CLASSRANKS_POI", "3")
+	t.Setenv("GEOCODEUR_SERVER_CORS_ALLOWORIGINS", "https://a.nl, https://b.nl")
+	setTestOverrides(t, "datasets.0.database.maxConnections=7", "ranking.weights.distance=0.5")
+
+	config, err := loadTestConfig(t, "geocodeur.conf", `{"datasets": [{"name": "staging"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Ranking.ClassRank("poi") != 3 {
+		t.Errorf("class rank of poi = %d, want 3", config.Ranking.ClassRank("poi"))
+	}
+
+	if strings.Join(config.Server.CORS.AllowOrigins, " ") != "https://a.nl https://b.nl" {
+		t.Errorf("allowed origins = %v, want https://a.nl and https://b.nl", config.Server.CORS.AllowOrigins)
+	}
+
+	if config.Datasets[0].Database.MaxConnections != 7 || config.Ranking.Weights.Distance != 0.5 {
+		t.Errorf("max connections and distance weight = %d %v, want 7 0.5", config.Datasets[0].Database.MaxConnections, config.Ranking.Weights.Distance)
+	}
+}
+
+func TestOverrideProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      string
+		value    string
+		override string
+		problem  string
+	}{
+		{"unknown field of a section", "GEOCODEUR_SERVER_PORTT", "8080", "", "environment variable GEOCODEUR_SERVER_PORTT: unknown key portt, did you mean port?"},
+		{"invalid env value", "GEOCODEUR_SERVER_PORT", "eighty", "", "environment variable GEOCODEUR_SERVER_PORT: invalid number eighty"},
+		{"override without value", "", "", "server.port", "override server.port should be formatted as key=value"},
+		{"override of a section", "", "", "server.cors=*", "override server.cors: a config section can't be set"},
+		{"override of a missing item", "", "", "datasets.3.name=x", "override datasets.3.name: no item 3 in a list of 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv(tt.env, tt.value)
+			}
+			if tt.override != "" {
+				setTestOverrides(t, tt.override)
+			}
+
+			_, err := loadTestConfig(t, "geocodeur.conf", `{}`)
+
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+
+			if !strings.Contains(strings.Join(invalid.Problems, "\n"), tt.problem) {
+				t.Errorf("problems %q don't contain %q", invalid.Problems, tt.problem)
+			}
+		})
+	}
+}
+
+func TestStrayEnvironmentVariables(t *testing.T) {
+	t.Setenv("GEOCODEUR_VERSION", "1.2.3")
+	t.Setenv("GEOCODEUR_DATABSE_NAME", "geocodeur")
+
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	config, err := loadTestConfig(t, "geocodeur.conf", `{}`)
+	if err != nil {
+		t.Fatalf("stray environment variables should not fail loading: %v", err)
+	}
+
+	if config.Database.Name != "geocodeur" {
+		t.Errorf("database name = %s, want the default geocodeur", config.Database.Name)
+	}
+
+	var warnings []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == log.WarnLevel {
+			warnings = append(warnings, entry.Message)
+		}
+	}
+
+	for _, want := range []string{"Ignoring environment variable GEOCODEUR_VERSION", "Ignoring environment variable GEOCODEUR_DATABSE_NAME, unknown key databse, did you mean database?"} {
+		if !strings.Contains(strings.Join(warnings, "\n"), want) {
+			t.Errorf("warnings %q don't contain %q", warnings, want)
+		}
+	}
+}
+
+func TestConfigFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"geocodeur.json", `{
+			"server": {"port": 9090},
+			"ranking": {"classRanks": {"road": 1}},
+			"datasets": [{"name": "lite", "backend": "sqlite", "file": "lite.sqlite"}]
+		}`},
+		{"geocodeur.yaml", `
+server:
+  port: 9090
+ranking:
+  classRanks:
+    road: 1
+datasets:
+  - name: lite
+    backend: sqlite
+    file: lite.sqlite
+`},
+		{"geocodeur.toml", `
+[server]
+port = 9090
+
+[ranking.classRanks]
+road = 1
+
+[[datasets]]
+name = "lite"
+backend = "sqlite"
+file = "lite.sqlite"
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := loadTestConfig(t, tt.name, tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.Server.Port != 9090 || config.Ranking.ClassRank("road") != 1 {
+				t.Errorf("port and road rank = %d %d, want 9090 1", config.Server.Port, config.Ranking.ClassRank("road"))
+			}
+
+			if len(config.Datasets) != 1 || config.Datasets[0].Backend != BackendSQLite || config.Datasets[0].File != "lite.sqlite" {
+				t.Errorf("datasets = %+v, want the sqlite dataset lite", config.Datasets)
+			}
+		})
+	}
+}
+
+func TestConfigFormatProblems(t *testing.T) {
+	for name, content := range map[string]string{
+		"geocodeur.yaml": "server:\n  prot: 9090\n",
+		"geocodeur.toml": "[server]\nprot = 9090\n",
+	} {
+		_, err := loadTestConfig(t, name, content)
+
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) || !strings.Contains(strings.Join(invalid.Problems, "\n"), "unknown key server.prot, did you mean port?") {
+			t.Errorf("%s: expected the unknown key server.prot, got %v", name, err)
+		}
+	}
+}
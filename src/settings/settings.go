@@ -2,21 +2,35 @@ package settings
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
-	"regexp"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tailscale/hujson"
+	"gopkg.in/yaml.v3"
 )
 
 var config Config
+var configMutex sync.RWMutex
 var configFile = getConfigLocation()
+var configFileSet bool
+var loadedFile string
 
 type Config struct {
-	Server   ServerConfig   `json:"server"`
-	API      APIConfig      `json:"api"`
-	Database DatabaseConfig `json:"database"`
-	Process  ProcessConfig  `json:"process"`
+	Server   ServerConfig    `json:"server"`
+	API      APIConfig       `json:"api"`
+	Database DatabaseConfig  `json:"database"`
+	Process  ProcessConfig   `json:"process"`
+	Ranking  RankingConfig   `json:"ranking"`
+	Aliases  AliasConfig     `json:"aliases"`
+	Tracing  TracingConfig   `json:"tracing"`
+	Datasets []DatasetConfig `json:"datasets"`
 }
 
 type ServerConfig struct {
@@ -24,21 +38,72 @@ type ServerConfig struct {
 	Debug                 bool       `json:"debug"`
 	CORS                  CorsConfig `json:"cors"`
 	MaxConcurrentRequests int        `json:"maxConcurrentRequests"`
-	Timeout               int        `json:"timeout"`
+	Timeout               int        `json:"timeoutSeconds"`
 }
 
 type APIConfig struct {
-	PGTRGMTreshold float64 `json:"similarityThreshold"`
+	PGTRGMTreshold   float64 `json:"similarityThreshold"`
+	StatementTimeout int     `json:"statementTimeoutMs"`
 }
 
 type DatabaseConfig struct {
 	Name             string `json:"name"`
 	Schema           string `json:"schema"`
 	Tablespace       string `json:"tablespace"`
+	OvertureTable    string `json:"overtureTable"`
+	SearchTable      string `json:"searchTable"`
 	ConnectionString string `json:"connectionString"`
 	MaxConnections   int32  `json:"maxConnections"`
 }
 
+// The storage backends a dataset can be served from.
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+	BackendIndex    = "index"
+)
+
+// DatasetConfig is a named dataset served by the API, every dataset has its own
+// database and can be limited to a set of classes. When no datasets are configured
+// the database config is served as the only dataset.
+type DatasetConfig struct {
+	Name     string         `json:"name"`
+	Backend  string         `json:"backend"`
+	Database DatabaseConfig `json:"database"`
+	File     string         `json:"file"`
+	Classes  []string       `json:"classes"`
+}
+
+// HasClass checks if a class is available in the dataset, all classes are available when no classes are configured.
+func (d DatasetConfig) HasClass(class string) bool {
+	if len(d.Classes) == 0 {
+		return true
+	}
+
+	for _, c := range d.Classes {
+		if strings.EqualFold(c, class) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Dataset returns the dataset with the given name, the first dataset is returned when name is empty.
+func (c Config) Dataset(name string) (DatasetConfig, error) {
+	if name == "" && len(c.Datasets) > 0 {
+		return c.Datasets[0], nil
+	}
+
+	for _, dataset := range c.Datasets {
+		if dataset.Name == name {
+			return dataset, nil
+		}
+	}
+
+	return DatasetConfig{}, fmt.Errorf("dataset '%s' not found", name)
+}
+
 type CorsConfig struct {
 	AllowOrigins []string `json:"allowOrigins"`
 	AllowHeaders []string `json:"allowHeaders"`
@@ -48,6 +113,68 @@ type CorsConfig struct {
 type ProcessConfig struct {
 	Folder      string `json:"folder"`
 	CountryClip string `json:"countryClip"`
+	// Release is the Overture release of the downloaded data, it's recorded when the data is loaded.
+	Release string `json:"release"`
+}
+
+// Exporters the spans of OpenTelemetry tracing can be written to.
+const (
+	TracingNone   = "none"
+	TracingOTLP   = "otlp"
+	TracingStdout = "stdout"
+	TracingFile   = "file"
+)
+
+// TracingConfig configures the OpenTelemetry tracing of requests, spans are sent to an OTLP
+// collector over HTTP or written as JSON to stdout or a file for local debugging.
+type TracingConfig struct {
+	Exporter    string  `json:"exporter"`
+	Endpoint    string  `json:"endpoint"`
+	File        string  `json:"file"`
+	SampleRatio float64 `json:"sampleRatio"`
+	ServiceName string  `json:"serviceName"`
+}
+
+type RankingConfig struct {
+	Weights       RankingWeights `json:"weights"`
+	ClassRanks    map[string]int `json:"classRanks"`
+	SubclassRanks map[string]int `json:"subclassRanks"`
+	DefaultRank   int            `json:"defaultRank"`
+}
+
+// AliasConfig contains the extra names features are searched on. A feature or division named like a
+// key of Names is also found by its value, for example 's-Hertogenbosch by Den Bosch. Truncations are
+// removed from names to find features by the rest of their name, Rijksweg A2 is also found by A2.
+type AliasConfig struct {
+	Names       map[string]string `json:"names"`
+	Truncations []string          `json:"truncations"`
+}
+
+// RankingWeights are the weights used to calculate the score of a result,
+// score = similarity*w - classRank*w - subclassRank*w + importance*w - distanceKm*w.
+// Results are ordered by score and then by similarity, class rank and subclass rank.
+type RankingWeights struct {
+	Similarity float64 `json:"similarity"`
+	Class      float64 `json:"class"`
+	Subclass   float64 `json:"subclass"`
+	Importance float64 `json:"importance"`
+	Distance   float64 `json:"distance"`
+}
+
+// ClassRank returns the configured rank for a class, lower ranks are better.
+func (r RankingConfig) ClassRank(class string) int {
+	if rank, ok := r.ClassRanks[class]; ok {
+		return rank
+	}
+	return r.DefaultRank
+}
+
+// SubclassRank returns the configured rank for a subclass, lower ranks are better.
+func (r RankingConfig) SubclassRank(subclass string) int {
+	if rank, ok := r.SubclassRanks[subclass]; ok {
+		return rank
+	}
+	return r.DefaultRank
 }
 
 // getConfigLocation returns the location of the Geocodeur configuration file.
@@ -61,45 +188,112 @@ func getConfigLocation() string {
 	return location
 }
 
-// InitializeConfig loads the configuration
-// returns an error if there was a problem loading the configuration.
-func InitializeConfig() error {
-	err := loadConfig()
+// SetConfigLocation sets the location of the configuration file, it overrides GEOCODEUR_CONFIG_PATH.
+func SetConfigLocation(location string) {
+	configFile = location
+	configFileSet = true
+}
+
+// LoadedFile returns the config file of the current configuration, empty when no config file was found.
+// A file that was rejected on reload is not reported.
+func LoadedFile() string {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return loadedFile
+}
+
+// readConfigFile reads the values of a config file, the format is taken from the extension: .yaml, .yml,
+// .toml or JSON for everything else. JSON may contain comments and trailing commas, YAML and TOML use the
+// same keys as JSON.
+func readConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %v", err)
+		return nil, err
 	}
 
-	return nil
-}
+	var values map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		data, err = hujson.Standardize(data)
+		if err == nil {
+			err = json.Unmarshal(data, &values)
+		}
+	}
 
-// loadConfig loads the configuration from a JSON file.
-// It reads the JSON file, unmarshals it into the 'config' variable,
-// and sets default values if necessary.
-// Returns an error if there was a problem reading or unmarshaling the JSON file.
-// loadConfig loads the configuration from a JSON file.
-// It reads the JSON file, unmarshals it into the 'config' variable,
-// and sets default values if necessary.
-// Returns an error if there was a problem reading or unmarshaling the JSON file.
-func loadConfig() error {
-	jsonFile, err := os.Open(configFile)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
 	}
-	defer jsonFile.Close()
 
-	byteValue, err := io.ReadAll(jsonFile)
+	return values, nil
+}
+
+// decodeConfig sets the config fields from the values of a config file.
+func decodeConfig(values map[string]any, config *Config) error {
+	data, err := json.Marshal(values)
 	if err != nil {
 		return err
 	}
 
-	// Preprocess the JSON to remove excessive commas
-	cleanedJSON := cleanJSON(string(byteValue))
+	return json.Unmarshal(data, config)
+}
 
-	err = json.Unmarshal([]byte(cleanedJSON), &config)
+// InitializeConfig loads the configuration
+// returns an error if there was a problem loading the configuration.
+func InitializeConfig() error {
+	loaded, file, err := loadConfig()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
+	configMutex.Lock()
+	config = loaded
+	loadedFile = file
+	configMutex.Unlock()
+
+	return nil
+}
+
+// Reload loads the configuration again from the same file, environment variables and overrides.
+// The current configuration is kept when the new configuration is invalid.
+func Reload() (Config, error) {
+	err := InitializeConfig()
+	return GetConfig(), err
+}
+
+// loadConfig loads the configuration from a JSON, YAML or TOML file, applies the environment
+// variables and overrides and sets default values if necessary. Without a config file only the
+// environment variables, overrides and defaults are used, unless the file was set with SetConfigLocation.
+// All problems in the configuration are returned at once as ValidationError. The config file is
+// returned as well, empty when no config file was found.
+func loadConfig() (Config, string, error) {
+	var config Config
+	var file string
+	var problems []string
+
+	values, err := readConfigFile(configFile)
+	if errors.Is(err, fs.ErrNotExist) && !configFileSet {
+		file = ""
+	} else if err != nil {
+		return Config{}, "", err
+	} else {
+		file = configFile
+		problems = append(problems, checkKeys(values, reflect.TypeOf(config), "")...)
+
+		// Fields with a wrong type are skipped, the others are still set
+		err = decodeConfig(values, &config)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	problems = append(problems, applyEnv(&config)...)
+	problems = append(problems, applyOverrides(&config)...)
+
 	if config.Server.Port == 0 {
 		config.Server.Port = 8080
 	}
@@ -133,27 +327,161 @@ func loadConfig() error {
 		config.API.PGTRGMTreshold = 0.45
 	}
 
-	if config.Database.Name == "" {
-		config.Database.Name = "geocodeur"
+	if config.API.StatementTimeout == 0 {
+		config.API.StatementTimeout = 10000
+	}
+
+	setDatabaseDefaults(&config.Database)
+	err = setDatasetDefaults(&config)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	setRankingDefaults(&config.Ranking)
+	setAliasDefaults(&config.Aliases)
+	setTracingDefaults(&config.Tracing)
+
+	problems = append(problems, validate(config)...)
+	if len(problems) > 0 {
+		return Config{}, "", &ValidationError{Problems: problems}
+	}
+
+	return config, file, nil
+}
+
+// setDatabaseDefaults fills in the database configuration that is not set.
+func setDatabaseDefaults(database *DatabaseConfig) {
+	if database.Name == "" {
+		database.Name = "geocodeur"
+	}
+
+	if database.Schema == "" {
+		database.Schema = "public"
+	}
+
+	if database.OvertureTable == "" {
+		database.OvertureTable = "overture"
+	}
+
+	if database.SearchTable == "" {
+		database.SearchTable = "overture_search"
+	}
+
+	if database.MaxConnections == 0 {
+		database.MaxConnections = 5
+	}
+}
+
+// setTracingDefaults disables tracing when no exporter is set and samples all requests by default.
+func setTracingDefaults(tracing *TracingConfig) {
+	if tracing.Exporter == "" {
+		tracing.Exporter = TracingNone
+	}
+
+	if tracing.SampleRatio == 0 {
+		tracing.SampleRatio = 1
+	}
+
+	if tracing.ServiceName == "" {
+		tracing.ServiceName = "geocodeur"
+	}
+}
+
+// setDatasetDefaults serves the database config as the only dataset when no datasets are configured,
+// datasets without a name are named after their database and are served from PostgreSQL by default.
+func setDatasetDefaults(config *Config) error {
+	if len(config.Datasets) == 0 {
+		config.Datasets = []DatasetConfig{{Name: config.Database.Name, Backend: BackendPostgres, Database: config.Database}}
+		return nil
 	}
 
-	if config.Database.MaxConnections == 0 {
-		config.Database.MaxConnections = 5
+	names := make(map[string]bool)
+	for i := range config.Datasets {
+		dataset := &config.Datasets[i]
+		setDatabaseDefaults(&dataset.Database)
+		if dataset.Name == "" {
+			dataset.Name = dataset.Database.Name
+		}
+
+		if dataset.Backend == "" {
+			dataset.Backend = BackendPostgres
+		}
+
+		if dataset.File == "" && dataset.Backend == BackendIndex {
+			dataset.File = dataset.Name + ".idx"
+		} else if dataset.File == "" {
+			dataset.File = dataset.Name + ".sqlite"
+		}
+
+		if names[dataset.Name] {
+			return fmt.Errorf("dataset '%s' is configured more than once", dataset.Name)
+		}
+		names[dataset.Name] = true
 	}
 
 	return nil
 }
 
-func cleanJSON(input string) string {
-	// Remove trailing commas before closing braces and brackets
-	re := regexp.MustCompile(`,\s*([\]}])`)
-	cleaned := re.ReplaceAllString(input, "$1")
-	// Ensure that there are no consecutive commas
-	cleaned = strings.ReplaceAll(cleaned, ",,", ",")
-	return cleaned
+// setRankingDefaults fills in the ranking configuration that is not set,
+// without any ranking config the results are ordered by similarity, class rank and subclass rank.
+func setRankingDefaults(ranking *RankingConfig) {
+	weights := ranking.Weights
+	if weights.Similarity == 0 && weights.Class == 0 && weights.Subclass == 0 && weights.Importance == 0 && weights.Distance == 0 {
+		ranking.Weights.Similarity = 1
+	}
+
+	if ranking.DefaultRank == 0 {
+		ranking.DefaultRank = 100
+	}
+
+	if len(ranking.ClassRanks) == 0 {
+		ranking.ClassRanks = map[string]int{
+			"division": 1,
+			"water":    1, // lot of division names with partly water name, maas, ijssel, etc, rank the same
+			"road":     2,
+			"infra":    3,
+			"address":  4,
+			"zipcode":  5,
+			"poi":      6,
+		}
+	}
+
+	if len(ranking.SubclassRanks) == 0 {
+		ranking.SubclassRanks = map[string]int{
+			"locality":      1,
+			"county":        2,
+			"neighborhood":  3,
+			"microhood":     4,
+			"motorway":      1,
+			"trunk":         2,
+			"primary":       3,
+			"secondary":     4,
+			"tertiary":      5,
+			"unclassified":  6,
+			"residential":   6,
+			"living_street": 6,
+		}
+	}
+}
+
+// setAliasDefaults sets the names and truncations that are not configured, an empty
+// object or list in the config disables them.
+func setAliasDefaults(aliases *AliasConfig) {
+	if aliases.Names == nil {
+		aliases.Names = map[string]string{
+			"'s-Hertogenbosch": "Den Bosch",
+		}
+	}
+
+	if aliases.Truncations == nil {
+		aliases.Truncations = []string{"Rijksweg"}
+	}
 }
 
 // GetConfig returns the current configuration.
 func GetConfig() Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
 	return config
 }
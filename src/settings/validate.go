@@ -0,0 +1,175 @@
+package settings
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/tebben/geocodeur/text"
+)
+
+// ValidationError lists all problems found in the configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d problems in the configuration: %s", len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// checkKeys returns a problem for every key in the values of a config file that is not a field of
+// the config type t. Like JSON decoding keys are matched case-insensitively.
+func checkKeys(values map[string]any, t reflect.Type, path string) []string {
+	var problems []string
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		field, ok := fieldByKey(t, key)
+		if !ok {
+			problems = append(problems, unknownKey(t, path, key))
+			continue
+		}
+
+		fieldPath := joinPath(path, key)
+		switch value := values[key].(type) {
+		case map[string]any:
+			if field.Type.Kind() == reflect.Struct {
+				problems = append(problems, checkKeys(value, field.Type, fieldPath)...)
+			}
+		case []any:
+			if field.Type.Kind() != reflect.Slice || field.Type.Elem().Kind() != reflect.Struct {
+				continue
+			}
+
+			for i, item := range value {
+				if item, ok := item.(map[string]any); ok {
+					problems = append(problems, checkKeys(item, field.Type.Elem(), fmt.Sprintf("%s[%d]", fieldPath, i))...)
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// fieldByKey returns the field of a struct type with the JSON key.
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if strings.EqualFold(jsonKey(t.Field(i)), key) {
+			return t.Field(i), true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// unknownKey describes an unknown key with the keys it's close to, or all keys when nothing is close.
+func unknownKey(t reflect.Type, path string, key string) string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, jsonKey(t.Field(i)))
+	}
+
+	if matches := closeMatches(key, keys); len(matches) > 0 {
+		return fmt.Sprintf("unknown key %s, did you mean %s?", joinPath(path, key), strings.Join(matches, " or "))
+	}
+
+	if path == "" {
+		return fmt.Sprintf("unknown key %s, the keys are %s", key, strings.Join(keys, ", "))
+	}
+	return fmt.Sprintf("unknown key %s, the keys of %s are %s", joinPath(path, key), path, strings.Join(keys, ", "))
+}
+
+// closeMatches returns the keys that are a few edits away from key or contain it, closest first.
+func closeMatches(key string, keys []string) []string {
+	key = strings.ToLower(key)
+	distances := make(map[string]int)
+	var matches []string
+	for _, candidate := range keys {
+		lower := strings.ToLower(candidate)
+		distance := text.EditDistance(key, lower)
+		if distance <= max(2, len(key)/3) || strings.Contains(lower, key) || strings.Contains(key, lower) {
+			distances[candidate] = distance
+			matches = append(matches, candidate)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return distances[matches[i]] < distances[matches[j]]
+	})
+
+	return matches
+}
+
+func jsonKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// validate returns a problem for every config value that is out of range, it's run after the defaults are set.
+func validate(config Config) []string {
+	var problems []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	server := config.Server
+	check(server.Port > 0 && server.Port <= 65535, "server.port must be between 1 and 65535, got %d", server.Port)
+	check(server.MaxConcurrentRequests > 0, "server.maxConcurrentRequests must be at least 1, got %d", server.MaxConcurrentRequests)
+	check(server.Timeout > 0, "server.timeoutSeconds must be at least 1, got %d", server.Timeout)
+
+	threshold := config.API.PGTRGMTreshold
+	check(threshold > 0 && threshold <= 1, "api.similarityThreshold must be between 0 and 1, got %v", threshold)
+	check(config.API.StatementTimeout > 0, "api.statementTimeoutMs must be at least 1, got %d", config.API.StatementTimeout)
+
+	check(config.Database.MaxConnections > 0, "database.maxConnections must be at least 1, got %d", config.Database.MaxConnections)
+	for i, dataset := range config.Datasets {
+		path := fmt.Sprintf("datasets[%d]", i)
+		check(dataset.Backend == BackendPostgres || dataset.Backend == BackendSQLite || dataset.Backend == BackendIndex,
+			"%s.backend must be %s, %s or %s, got '%s'", path, BackendPostgres, BackendSQLite, BackendIndex, dataset.Backend)
+		check(dataset.Database.MaxConnections > 0, "%s.database.maxConnections must be at least 1, got %d", path, dataset.Database.MaxConnections)
+	}
+
+	tracing := config.Tracing
+	check(tracing.Exporter == TracingNone || tracing.Exporter == TracingOTLP || tracing.Exporter == TracingStdout || tracing.Exporter == TracingFile,
+		"tracing.exporter must be %s, %s, %s or %s, got '%s'", TracingNone, TracingOTLP, TracingStdout, TracingFile, tracing.Exporter)
+	check(tracing.Exporter != TracingFile || tracing.File != "", "tracing.file must be set when tracing.exporter is %s", TracingFile)
+	check(tracing.SampleRatio > 0 && tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1, got %v", tracing.SampleRatio)
+
+	for name, alias := range config.Aliases.Names {
+		check(strings.TrimSpace(name) != "" && strings.TrimSpace(alias) != "", "aliases.names must not contain empty names or aliases, got '%s': '%s'", name, alias)
+	}
+	for _, truncation := range config.Aliases.Truncations {
+		check(strings.TrimSpace(truncation) != "", "aliases.truncations must not contain empty values")
+	}
+
+	weights := config.Ranking.Weights
+	for _, weight := range []struct {
+		name  string
+		value float64
+	}{
+		{"similarity", weights.Similarity},
+		{"class", weights.Class},
+		{"subclass", weights.Subclass},
+		{"importance", weights.Importance},
+		{"distance", weights.Distance},
+	} {
+		check(weight.value >= 0, "ranking.weights.%s must not be negative, got %v", weight.name, weight.value)
+	}
+
+	return problems
+}
@@ -0,0 +1,127 @@
+package settings
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestConfig loads a config file with the given name and content, the config location is restored afterwards.
+func loadTestConfig(t *testing.T, name string, content string) (Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	location, set := configFile, configFileSet
+	t.Cleanup(func() {
+		configFile, configFileSet = location, set
+	})
+	SetConfigLocation(path)
+
+	config, _, err := loadConfig()
+	return config, err
+}
+
+func TestConfigProblems(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		problem string
+	}{
+		{"unknown key", `{"databse": {}}`, "unknown key databse, did you mean database?"},
+		{"unknown nested key", `{"server": {"prot": 8080}}`, "unknown key server.prot, did you mean port?"},
+		{"unknown key without match", `{"server": {"zzzzzzzz": 1}}`, "unknown key server.zzzzzzzz, the keys of server are port, debug"},
+		{"unknown key in list", `{"datasets": [{"nmae": "staging"}]}`, "unknown key datasets[0].nmae, did you mean name?"},
+		{"wrong type", `{"server": {"port": "eighty"}}`, "cannot unmarshal string"},
+		{"port out of range", `{"server": {"port": 70000}}`, "server.port must be between 1 and 65535, got 70000"},
+		{"negative timeout", `{"server": {"timeoutSeconds": -1}}`, "server.timeoutSeconds must be at least 1, got -1"},
+		{"threshold out of range", `{"api": {"similarityThreshold": 1.5}}`, "api.similarityThreshold must be between 0 and 1, got 1.5"},
+		{"negative weight", `{"ranking": {"weights": {"distance": -1}}}`, "ranking.weights.distance must not be negative, got -1"},
+		{"unknown backend", `{"datasets": [{"name": "a", "backend": "mysql"}]}`, "datasets[0].backend must be postgres, sqlite or index, got 'mysql'"},
+		{"tracing file without file", `{"tracing": {"exporter": "file"}}`, "tracing.file must be set when tracing.exporter is file"},
+		{"empty alias", `{"aliases": {"names": {"Den Haag": ""}}}`, "aliases.names must not contain empty names or aliases"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, "geocodeur.conf", tt.content)
+
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+
+			found := false
+			for _, problem := range invalid.Problems {
+				found = found || strings.Contains(problem, tt.problem)
+			}
+
+			if !found {
+				t.Errorf("problems %q don't contain %q", invalid.Problems, tt.problem)
+			}
+		})
+	}
+}
+
+func TestConfigProblemsAtOnce(t *testing.T) {
+	_, err := loadTestConfig(t, "geocodeur.conf", `{"server": {"prot": 1, "port": 0, "timeoutSeconds": -1}, "api": {"similarityThreshold": 2}}`)
+
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	if len(invalid.Problems) != 3 {
+		t.Errorf("got %d problems, want 3: %q", len(invalid.Problems), invalid.Problems)
+	}
+}
+
+func TestJSONWithCommentsAndTrailingCommas(t *testing.T) {
+	config, err := loadTestConfig(t, "geocodeur.conf", `{
+		// The port to listen on
+		"server": {
+			"port": 9090, /* not the default */
+		},
+		"ranking": {
+			"classRanks": {"division": 2, "road": 1,},
+		},
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Server.Port != 9090 {
+		t.Errorf("port = %d, want 9090", config.Server.Port)
+	}
+
+	if config.Ranking.ClassRank("road") != 1 || config.Ranking.ClassRank("division") != 2 {
+		t.Errorf("class ranks = %v, want road 1 and division 2", config.Ranking.ClassRanks)
+	}
+}
+
+func TestCloseMatches(t *testing.T) {
+	keys := []string{"port", "debug", "cors", "maxConcurrentRequests", "timeoutSeconds"}
+
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{"prot", []string{"port"}},
+		{"PORT", []string{"port", "cors"}},
+		{"maxConcurrent", []string{"maxConcurrentRequests"}},
+		{"timeout", []string{"timeoutSeconds"}},
+		{"zzzzzzzz", nil},
+	}
+
+	for _, tt := range tests {
+		got := closeMatches(tt.key, keys)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("closeMatches(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package tracing sets up the OpenTelemetry tracing of requests, geocode searches and SQL statements.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/tebben/geocodeur/settings"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Start sets the global tracer provider exporting spans to the exporter of the config, the
+// returned function flushes the remaining spans and stops the exporter. Nothing is traced when
+// the exporter is none. Trace context is propagated with the W3C traceparent header.
+func Start(config settings.TracingConfig, version string) (func(ctx context.Context) error, error) {
+	if config.Exporter == settings.TracingNone {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	exporter, closeExporter, err := newExporter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(config.ServiceName),
+		semconv.ServiceVersion(version),
+	)
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	shutdown := func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closeExporter(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	return shutdown, nil
+}
+
+// newExporter creates the span exporter of the config and a function closing the file it writes to.
+func newExporter(config settings.TracingConfig) (sdktrace.SpanExporter, func() error, error) {
+	noClose := func() error { return nil }
+
+	switch config.Exporter {
+	case settings.TracingOTLP:
+		// Without an endpoint the OTEL_EXPORTER_OTLP_ environment variables or localhost:4318 are used
+		var options []otlptracehttp.Option
+		if config.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(config.Endpoint))
+		}
+
+		exporter, err := otlptracehttp.New(context.Background(), options...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating OTLP exporter: %v", err)
+		}
+		return exporter, noClose, nil
+	case settings.TracingStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating stdout exporter: %v", err)
+		}
+		return exporter, noClose, nil
+	case settings.TracingFile:
+		file, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening trace file %s: %v", config.File, err)
+		}
+
+		// One span per line
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("error creating file exporter: %v", err)
+		}
+		return exporter, file.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown tracing exporter '%s'", config.Exporter)
+	}
+}